@@ -0,0 +1,55 @@
+package robin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The first file descriptor passed by socket activation, 0-2 are stdin, stdout and stderr
+const listenFdsStart = 3
+
+// ActivationListeners returns the listeners passed to the process with systemd-style socket activation (i.e. `LISTEN_PID`, `LISTEN_FDS` and `LISTEN_FDNAMES`), this is empty if the process was not socket activated
+//
+// NOTE: the environment variables are unset so that child processes don't try to use the same file descriptors, which means this only returns the listeners the first time it is called
+func ActivationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS value: %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := range count {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// The listener gets its own duplicate of the file descriptor, so the original can be closed right away
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to use socket activation file descriptor %d (%s): %w", listenFdsStart+i, name, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
@@ -4,12 +4,28 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"time"
 
+	"go.trulyao.dev/robin/internal/guarded"
+	"go.trulyao.dev/robin/internal/validation"
 	"go.trulyao.dev/robin/types"
 )
 
 type ProcedureFn[Out any, In any] func(ctx *Context, body In) (Out, error)
 
+// SubscriptionFn is the handler for a subscription, values are pushed to the subscriber through the sink until the function returns
+type SubscriptionFn[Out any, In any] func(ctx *Context, body In, sink *Sink[Out]) error
+
+// Sink is used by subscriptions to push values of the declared type to the subscriber
+type Sink[T any] struct {
+	emit func(any) error
+}
+
+// Send pushes a value to the subscriber, an error is returned if the value could not be delivered (e.g. the subscriber went away) in which case the subscription should stop
+func (s *Sink[T]) Send(value T) error {
+	return s.emit(value)
+}
+
 type baseProcedure[Out any, In any] struct {
 	// The name of the procedure
 	name string
@@ -28,6 +44,22 @@ type baseProcedure[Out any, In any] struct {
 	// Middleware functions to be executed before the mutation is called
 	middlewareFns []types.Middleware
 
+	// Middleware functions that wrap the whole call
+	aroundMiddlewareFns []types.AroundMiddleware
+
+	// Guards to be executed after the payload has been decoded and before the procedure is called
+	guards []types.Guard
+
+	// The keys that the procedure needs its middleware to set and the ones its own middleware set
+	requiredKeys []types.ContextKey
+	providedKeys []types.ContextKey
+
+	// The errors that the procedure declares it can return
+	declaredErrors []types.CodedError
+
+	// Handles the procedure's errors instead of the global error handler if set
+	errorHandler types.ContextErrorHandler
+
 	// Indicates whether the procedure expects a payload, and if so, what type of payload it expects
 	expectedPayloadType types.ExpectedPayloadType
 
@@ -36,6 +68,15 @@ type baseProcedure[Out any, In any] struct {
 
 	// The procedure alias
 	alias string
+
+	// The maximum duration the procedure is allowed to run for, zero means the default timeout applies
+	timeout time.Duration
+
+	// Indicates whether the procedure returns a channel or an iterator that should be streamed to the caller, and if so, what kind
+	streamKind guarded.StreamKind
+
+	// The type of the values produced by the stream, this is only set when the procedure streams its result
+	streamElemType reflect.Type
 }
 
 func (b *baseProcedure[_, _]) Name() string {
@@ -55,11 +96,152 @@ func (b *baseProcedure[_, _]) PayloadInterface() any {
 	return b.in.InferredType()
 }
 
+// NewPayload returns a pointer to a new zero value of the payload type, the request body is decoded straight into this so that anything `encoding/json` can decode works as a payload
+func (b *baseProcedure[_, In]) NewPayload() any {
+	return new(In)
+}
+
+// castPayload converts the payload passed to `Call` into the type the procedure expects
+//
+// NOTE: values that are neither `In` nor `*In` (e.g. maps from a generic decoder) are converted as a last resort, this is slower and lossy, so transports should always decode into `NewPayload`
+func (b *baseProcedure[_, In]) castPayload(raw any) (In, error) {
+	switch payload := raw.(type) {
+	case nil:
+		var zero In
+		return zero, nil
+	case *In:
+		if payload == nil {
+			var zero In
+			return zero, nil
+		}
+		return *payload, nil
+	case In:
+		return payload, nil
+	}
+
+	return guarded.CastType(raw, b.in.InferredType())
+}
+
+// validatePayloadRules makes sure the rules in the `validate` tags of the payload type are valid, so that mistakes are caught when the instance is built rather than on the first request
+func (b *baseProcedure[_, In]) validatePayloadRules() error {
+	if b.expectedPayloadType != types.ExpectedPayloadDecoded {
+		return nil
+	}
+
+	if _, err := validation.For(reflect.TypeFor[In]()); err != nil {
+		return RobinError{Reason: fmt.Sprintf("Procedure `%s` has invalid validation rules: %s", b.name, err), OriginalError: err}
+	}
+
+	return nil
+}
+
 // ReturnInterface returns a placeholder variable with the type of the return value of the procedure, this value is empty and only used for type inference/reflection during runtime
+//
+// NOTE: for procedures that stream their result, this is the type of each value in the stream
 func (b *baseProcedure[_, _]) ReturnInterface() any {
+	if b.streamKind != guarded.StreamNone {
+		return reflect.Zero(b.streamElemType).Interface()
+	}
+
 	return b.out
 }
 
+// AroundMiddlewareFns returns the middleware functions that wrap the whole call
+func (b *baseProcedure[_, _]) AroundMiddlewareFns() []types.AroundMiddleware {
+	return b.aroundMiddlewareFns
+}
+
+// Guards returns the guards to be executed after the payload has been decoded and before the procedure is called
+func (b *baseProcedure[_, _]) Guards() []types.Guard {
+	return b.guards
+}
+
+// RequiredKeys returns the keys that the procedure needs its middleware to set
+func (b *baseProcedure[_, _]) RequiredKeys() []types.ContextKey {
+	return b.requiredKeys
+}
+
+// ProvidedKeys returns the keys that the procedure's own middleware set
+func (b *baseProcedure[_, _]) ProvidedKeys() []types.ContextKey {
+	return b.providedKeys
+}
+
+// DeclaredErrors returns the errors the procedure declares it can return
+func (b *baseProcedure[_, _]) DeclaredErrors() []types.CodedError {
+	return b.declaredErrors
+}
+
+// ErrorHandler returns the procedure's own error handler, if any
+func (b *baseProcedure[_, _]) ErrorHandler() types.ContextErrorHandler {
+	return b.errorHandler
+}
+
+// validateDeclaredErrors ensures that every declared error has a code that identifies it
+func (b *baseProcedure[_, _]) validateDeclaredErrors() error {
+	seen := make(map[string]bool, len(b.declaredErrors))
+
+	for _, declared := range b.declaredErrors {
+		code := declared.ErrorCode()
+
+		switch {
+		case code == "":
+			return RobinError{Reason: fmt.Sprintf("Procedure `%s` declares an error of type `%T` without a code", b.name, declared)}
+
+		case code == UnexpectedErrorCode:
+			return RobinError{Reason: fmt.Sprintf("Procedure `%s` declares an error with the reserved code `%s`", b.name, code)}
+
+		case seen[code]:
+			return RobinError{Reason: fmt.Sprintf("Procedure `%s` declares more than one error with the code `%s`", b.name, code)}
+		}
+
+		seen[code] = true
+	}
+
+	return nil
+}
+
+// typedGuard adapts a guard that expects the payload type of a procedure to a regular guard
+func typedGuard[In any](fn func(ctx *Context, payload In) error) types.Guard {
+	return func(ctx *Context, payload any) error {
+		if payload == nil {
+			var zero In
+			return fn(ctx, zero)
+		}
+
+		typed, ok := payload.(In)
+		if !ok {
+			err := fmt.Errorf("expected payload of type %s, got %T", reflect.TypeFor[In](), payload)
+			return RobinError{Reason: "Guard received a payload of the wrong type", OriginalError: err}
+		}
+
+		return fn(ctx, typed)
+	}
+}
+
+// Timeout returns the maximum duration the procedure is allowed to run for, zero means the default timeout applies
+func (b *baseProcedure[_, _]) Timeout() time.Duration {
+	return b.timeout
+}
+
+// IsStreaming returns whether the procedure streams its result or not
+func (b *baseProcedure[_, _]) IsStreaming() bool {
+	return b.streamKind != guarded.StreamNone
+}
+
+// detectStream checks if the return type of the procedure is a channel or an iterator and records that so the result can be streamed
+func (b *baseProcedure[Out, _]) detectStream() {
+	b.streamKind, b.streamElemType = guarded.DetectStream(reflect.TypeFor[Out]())
+}
+
+// toResult converts the output of the procedure into a stream if the procedure streams its result
+func (b *baseProcedure[Out, _]) toResult(ctx *Context, out Out) any {
+	if b.streamKind == guarded.StreamNone {
+		return out
+	}
+
+	return guarded.ToStream(b.streamKind, out, ctx.Done())
+}
+
 // ExpectsPayload returns whether the procedure expects a payload or not
 func (b *baseProcedure[_, _]) ExpectedPayloadType() types.ExpectedPayloadType {
 	return b.expectedPayloadType
@@ -0,0 +1,256 @@
+package robin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"go.trulyao.dev/robin/codec"
+	"go.trulyao.dev/robin/types"
+)
+
+const (
+	// The query parameter that marks a request as a batch of procedure calls e.g. `?__batch=1`
+	BatchKey = ProcSeparator + "batch"
+
+	// Default maximum number of calls allowed in a single batch request
+	DefaultMaxBatchSize = 25
+
+	// Default maximum number of calls in a batch executed at the same time when parallel execution is enabled
+	DefaultMaxBatchConcurrency = 8
+)
+
+type (
+	BatchOptions struct {
+		// Disable batched procedure calls entirely
+		Disable bool
+
+		// Maximum number of calls allowed in a single batch request (default is 25)
+		MaxSize int
+
+		// Whether to execute the calls in a batch concurrently or not
+		//
+		// NOTE: the results are always returned in the same order as the calls regardless of this option
+		Parallel bool
+
+		// Maximum number of calls in a batch executed at the same time when `Parallel` is enabled (default is 8)
+		MaxConcurrency int
+	}
+
+	// A single procedure call in a batch request
+	batchCall struct {
+		Type    ProcedureType   `json:"type"`
+		Name    string          `json:"name"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+
+	// The result of a single procedure call in a batch request
+	batchResult struct {
+		Ok    bool         `json:"ok"`
+		Data  any          `json:"data,omitempty"`
+		Error Serializable `json:"error,omitempty"`
+	}
+
+	// batchResponseWriter collects the headers set by a single call in a batch, the body and status code are discarded since the results are written as a part of the batch response
+	batchResponseWriter struct {
+		header http.Header
+	}
+)
+
+func (w *batchResponseWriter) Header() http.Header         { return w.header }
+func (w *batchResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *batchResponseWriter) WriteHeader(int)             {}
+
+// handleBatchCall handles a batch of procedure calls sent in a single request, each call is executed with its own context and middleware chain, and the results are returned in the same order as the calls
+func (r *Robin) handleBatchCall(w http.ResponseWriter, req *http.Request) error {
+	if r.batchOptions.Disable {
+		return types.Error{Message: "Batched procedure calls are disabled", Code: http.StatusBadRequest}
+	}
+
+	var data struct {
+		Calls []batchCall `json:"d"`
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return types.Error{Message: "Failed to read request body", Code: http.StatusBadRequest, Cause: err}
+	}
+
+	// The calls are always decoded as JSON since each payload is passed on to its procedure as is, the results are still encoded with the negotiated codec
+	if err := codec.JSON.Unmarshal(body, &data); err != nil {
+		return types.Error{
+			Message: "Invalid batch payload, expected a JSON array of `{type, name, payload}` calls",
+			Code:    http.StatusBadRequest,
+			Cause:   err,
+		}
+	}
+
+	maxSize := r.batchOptions.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBatchSize
+	}
+
+	if len(data.Calls) > maxSize {
+		return types.Error{
+			Message: fmt.Sprintf("Too many calls in batch, expected at most %d, got %d", maxSize, len(data.Calls)),
+			Code:    http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	var (
+		results = make([]batchResult, len(data.Calls))
+		writers = make([]*batchResponseWriter, len(data.Calls))
+	)
+
+	for idx := range data.Calls {
+		writers[idx] = &batchResponseWriter{header: make(http.Header)}
+	}
+
+	if r.batchOptions.Parallel {
+		maxConcurrency := r.batchOptions.MaxConcurrency
+		if maxConcurrency <= 0 {
+			maxConcurrency = DefaultMaxBatchConcurrency
+		}
+
+		var (
+			wg        sync.WaitGroup
+			semaphore = make(chan struct{}, maxConcurrency)
+			panics    = make([]*capturedPanic, len(data.Calls))
+		)
+
+		for idx, call := range data.Calls {
+			wg.Add(1)
+			semaphore <- struct{}{}
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				// A panic in another goroutine can't be recovered by the server, so it is carried over to the request goroutine (see below)
+				defer func() {
+					if e := recover(); e != nil {
+						captured := capturePanic(e)
+						panics[idx] = &captured
+					}
+				}()
+
+				results[idx] = r.runBatchCall(req, writers[idx], call)
+			}()
+		}
+		wg.Wait()
+
+		// Panics are only trapped in `runBatchCall` if `TrapPanic` is enabled, otherwise they are re-raised here like they would be for a single call
+		for _, captured := range panics {
+			if captured != nil {
+				panic(*captured)
+			}
+		}
+	} else {
+		for idx, call := range data.Calls {
+			results[idx] = r.runBatchCall(req, writers[idx], call)
+		}
+	}
+
+	// Merge the headers (cookies etc.) set by the individual calls into the actual response in the order of the calls
+	for _, writer := range writers {
+		for key, values := range writer.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+	}
+
+	c := r.responseCodec(req)
+	encodedResponse, err := c.Marshal(map[string]any{"ok": true, "data": results})
+	if err != nil {
+		return RobinError{Reason: "Failed to marshal batch response", OriginalError: err}
+	}
+
+	w.Header().Set("Content-Type", c.ContentType())
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(encodedResponse); err != nil {
+		slog.Error("Failed to write response", slog.String("error", err.Error()))
+	}
+
+	return nil
+}
+
+// runBatchCall executes a single call in a batch and converts the outcome into a result envelope
+func (r *Robin) runBatchCall(req *http.Request, w http.ResponseWriter, call batchCall) (result batchResult) {
+	ctx := types.NewContext(newPayloadRequest(req, call.Payload), &w)
+	ctx.SetProcedureName(call.Name)
+	ctx.SetProcedureType(call.Type)
+
+	if r.trapPanic {
+		defer func() {
+			if e := recover(); e != nil {
+				result = r.makeBatchErrorResult(ctx, r.makePanicError(ctx, e))
+			}
+		}()
+	}
+
+	switch call.Type {
+	case ProcedureTypeQuery, ProcedureTypeMutation:
+	default:
+		return r.makeBatchErrorResult(ctx, types.RobinError{
+			Reason: fmt.Sprintf("Invalid procedure type, expect one of 'query' or 'mutation', got %s", string(call.Type)),
+		})
+	}
+
+	procedure, found := r.findProcedure(call.Name, call.Type)
+	if !found {
+		return r.makeBatchErrorResult(ctx, r.makeMissingProcedureError(call.Name, call.Type))
+	}
+
+	// A streamed result can't be embedded in the batch response, so these have to be called on their own
+	if procedure.IsStreaming() {
+		return r.makeBatchErrorResult(ctx, types.Error{
+			Message: fmt.Sprintf("Procedure `%s` streams its result and cannot be batched", call.Name),
+			Code:    http.StatusBadRequest,
+		})
+	}
+
+	data, err := r.callProcedure(ctx, procedure)
+	if err != nil {
+		return r.makeBatchErrorResult(ctx, err)
+	}
+
+	return batchResult{Ok: true, Data: data}
+}
+
+// makeBatchErrorResult converts an error into a batch result envelope using the configured error handler
+func (r *Robin) makeBatchErrorResult(ctx *Context, err error) batchResult {
+	if r.debug {
+		slog.Error("An error occurred in batched call", slog.Any("error", err))
+	}
+
+	errorResponse, _ := r.handleError(ctx, err)
+	return batchResult{Ok: false, Error: errorResponse}
+}
+
+// newPayloadRequest creates a shallow copy of the request with the body replaced by the provided payload wrapped in the `d` key as the procedure handlers expect
+func newPayloadRequest(req *http.Request, payload json.RawMessage) *http.Request {
+	clone := req.Clone(req.Context())
+
+	// The payload is always JSON regardless of what the original request was sent with
+	clone.Header.Set("Content-Type", codec.JSON.ContentType())
+
+	if len(payload) == 0 {
+		clone.Body = http.NoBody
+		clone.ContentLength = 0
+		return clone
+	}
+
+	body := make([]byte, 0, len(payload)+6)
+	body = append(body, `{"d":`...)
+	body = append(body, payload...)
+	body = append(body, '}')
+
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	return clone
+}
@@ -0,0 +1,247 @@
+package robin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"go.trulyao.dev/robin"
+)
+
+type batchResponse struct {
+	Ok   bool `json:"ok"`
+	Data []struct {
+		Ok    bool            `json:"ok"`
+		Data  json.RawMessage `json:"data"`
+		Error json.RawMessage `json:"error"`
+	} `json:"data"`
+}
+
+func newBatchInstance(t *testing.T, opts robin.BatchOptions) *robin.Instance {
+	t.Helper()
+
+	r, err := robin.New(robin.Options{BatchOptions: opts})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("ping", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "pong", nil
+		})).
+		Add(robin.Query("fail", func(ctx *robin.Context, _ robin.Void) (robin.Void, error) {
+			return robin.Void{}, errors.New("failed")
+		})).
+		Add(robin.Mutation("double", func(ctx *robin.Context, n int) (int, error) {
+			return n * 2, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	return instance
+}
+
+func Test_BatchCall(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		instance := newBatchInstance(t, robin.BatchOptions{Parallel: parallel})
+
+		body := `{"d": [
+			{"type": "query", "name": "ping"},
+			{"type": "mutation", "name": "double", "payload": 21},
+			{"type": "query", "name": "fail"}
+		]}`
+
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.BatchKey+"=1", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d (%s)", rec.Code, rec.Body.String())
+		}
+
+		var response batchResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		if len(response.Data) != 3 {
+			t.Fatalf("expected 3 results, got %d", len(response.Data))
+		}
+
+		expected := []struct {
+			ok   bool
+			data string
+		}{
+			{true, `"pong"`},
+			{true, `42`},
+			{false, ""},
+		}
+
+		for idx, want := range expected {
+			got := response.Data[idx]
+			if got.Ok != want.ok {
+				t.Errorf("[parallel=%t] result %d: expected ok=%t, got %t", parallel, idx, want.ok, got.Ok)
+			}
+
+			if want.ok && string(got.Data) != want.data {
+				t.Errorf("[parallel=%t] result %d: expected data %s, got %s", parallel, idx, want.data, got.Data)
+			}
+
+			if !want.ok && len(got.Error) == 0 {
+				t.Errorf("[parallel=%t] result %d: expected an error, got none", parallel, idx)
+			}
+		}
+	}
+}
+
+// directCBOR encodes values straight from their Go types (unlike `codec.CBOR`), so nothing it decodes can be handed on as JSON
+type directCBOR struct{}
+
+func (directCBOR) ContentType() string { return "application/cbor" }
+
+func (directCBOR) Marshal(v any) ([]byte, error) { return cbor.Marshal(v) }
+
+func (directCBOR) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+
+func Test_BatchCallWithBinaryCodec(t *testing.T) {
+	binary := directCBOR{}
+
+	r, err := robin.New(robin.Options{Codecs: []robin.Codec{binary}})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Mutation("double", func(ctx *robin.Context, n int) (int, error) {
+			return n * 2, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	call := func(body []byte, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.BatchKey+"=1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", binary.ContentType())
+
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+		return rec
+	}
+
+	// The calls are always sent as JSON, only the results use the negotiated codec
+	rec := call([]byte(`{"d": [{"type": "mutation", "name": "double", "payload": 21}]}`), "application/json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != binary.ContentType() {
+		t.Fatalf("expected content type %s, got %s", binary.ContentType(), contentType)
+	}
+
+	var response struct {
+		Ok   bool `json:"ok"`
+		Data []struct {
+			Ok   bool `json:"ok"`
+			Data int  `json:"data"`
+		} `json:"data"`
+	}
+	if err := binary.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if !response.Ok || len(response.Data) != 1 || !response.Data[0].Ok || response.Data[0].Data != 42 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+
+	body, err := binary.Marshal(map[string]any{"d": []map[string]any{{"type": "mutation", "name": "double", "payload": 21}}})
+	if err != nil {
+		t.Fatalf("failed to marshal batch: %v", err)
+	}
+
+	if rec := call(body, binary.ContentType()); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a batch sent with a binary codec to be rejected with status 400, got %d", rec.Code)
+	}
+}
+
+func Test_BatchCallMaxSize(t *testing.T) {
+	instance := newBatchInstance(t, robin.BatchOptions{MaxSize: 1})
+
+	body := `{"d": [{"type": "query", "name": "ping"}, {"type": "query", "name": "ping"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/?"+robin.BatchKey+"=1", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	instance.Handler()(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func Test_BatchCallParallelPanic(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+
+	r, err := robin.New(robin.Options{BatchOptions: robin.BatchOptions{Parallel: true, MaxConcurrency: 2}})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("slow", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return "done", nil
+		})).
+		Add(robin.Query("explode", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			panic("something broke")
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	call := func(body string) (recovered any) {
+		defer func() { recovered = recover() }()
+
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.BatchKey+"=1", strings.NewReader(body))
+		instance.Handler()(httptest.NewRecorder(), req)
+		return nil
+	}
+
+	slow := `{"type": "query", "name": "slow"}`
+	if recovered := call(`{"d": [` + strings.Repeat(slow+",", 5) + slow + `]}`); recovered != nil {
+		t.Fatalf("expected no panic, got %v", recovered)
+	}
+
+	if peak > 2 {
+		t.Errorf("expected at most 2 calls to run at the same time, got %d", peak)
+	}
+
+	// Without `TrapPanic`, the panic has to reach the request goroutine (where net/http recovers it) instead of crashing the process
+	recovered := call(`{"d": [` + slow + `, {"type": "query", "name": "explode"}]}`)
+	if recovered == nil || !strings.Contains(fmt.Sprint(recovered), "something broke") {
+		t.Errorf("expected the panic to be re-raised on the request goroutine, got %v", recovered)
+	}
+}
@@ -0,0 +1,47 @@
+package robin
+
+import (
+	"net/http"
+	"strings"
+
+	"go.trulyao.dev/robin/codec"
+)
+
+// Codec is re-exported for convenience, see the `codec` package for the built-in implementations
+type Codec = codec.Codec
+
+// DefaultCodecs are the codecs available for content negotiation when none are provided in the options
+var DefaultCodecs = []Codec{codec.JSON, codec.MessagePack, codec.CBOR}
+
+// findCodec returns the registered codec for the media type if there is one
+func (r *Robin) findCodec(mediaType string) (Codec, bool) {
+	for _, c := range r.codecs {
+		if c.ContentType() == mediaType {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// requestCodec returns the codec matching the request's `Content-Type`, JSON is assumed if there is no match to stay compatible with clients that don't set it correctly
+func (r *Robin) requestCodec(req *http.Request) Codec {
+	if c, ok := r.findCodec(codec.MediaType(req.Header.Get("Content-Type"))); ok {
+		return c
+	}
+
+	return codec.JSON
+}
+
+// responseCodec picks the codec for the response from the request's `Accept` header in the order the media types are listed, falling back to the codec the request was sent with
+func (r *Robin) responseCodec(req *http.Request) Codec {
+	for _, value := range req.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(value, ",") {
+			if c, ok := r.findCodec(codec.MediaType(mediaType)); ok {
+				return c
+			}
+		}
+	}
+
+	return r.requestCodec(req)
+}
@@ -0,0 +1,52 @@
+package codec
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+type cborCodec struct {
+	encoder cbor.EncMode
+	decoder cbor.DecMode
+}
+
+// newCBORCodec creates the CBOR codec, the options are constant so this never fails
+func newCBORCodec() cborCodec {
+	// Map keys are sorted so that the same value is always encoded the same way (RFC 8949 section 4.2.1)
+	encoder, err := cbor.EncOptions{Sort: cbor.SortBytewiseLexical, ShortestFloat: cbor.ShortestFloat16}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	// Tags only annotate the value that follows, so only their content is kept
+	decoder, err := cbor.DecOptions{
+		MaxNestedLevels:      maxDepth,
+		BigIntDec:            cbor.BigIntDecodePointer,
+		UnrecognizedTagToAny: cbor.UnrecognizedTagContentToAny,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return cborCodec{encoder: encoder, decoder: decoder}
+}
+
+func (cborCodec) ContentType() string { return "application/cbor" }
+
+func (c cborCodec) Marshal(v any) ([]byte, error) {
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.encoder.Marshal(generic)
+}
+
+// Unmarshal rejects malformed data and trailing bytes
+func (c cborCodec) Unmarshal(data []byte, v any) error {
+	var generic any
+	if err := c.decoder.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+
+	return fromGeneric(generic, v)
+}
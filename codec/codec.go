@@ -0,0 +1,143 @@
+// Package codec provides the wire formats robin can use to decode payloads and encode responses, any other format can be plugged in by implementing `Codec`
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Codec encodes and decodes values for a single wire format, implementations must be safe for concurrent use
+type Codec interface {
+	// The media type of the wire format (e.g. `application/json`), this is used for content negotiation
+	ContentType() string
+
+	// Marshal encodes the value
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes the data into the value pointed to by v
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON is the default codec
+	JSON Codec = jsonCodec{}
+
+	// MessagePack encodes values as MessagePack (https://msgpack.org)
+	MessagePack Codec = newMsgpackCodec()
+
+	// CBOR encodes values as CBOR (RFC 8949)
+	CBOR Codec = newCBORCodec()
+)
+
+// The maximum nesting depth allowed when decoding binary formats, this guards against stack exhaustion on malicious payloads
+const maxDepth = 1000
+
+var ErrTrailingData = errors.New("codec: unexpected trailing data")
+
+// MediaType returns the media type from a `Content-Type` (or `Accept`) header value without any parameters, lowercased
+func MediaType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(value, ";")[0]))
+	}
+
+	return mediaType
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// The binary codecs go through the same generic representation `encoding/json` uses before the values are handed to the libraries, so struct tags, custom `MarshalJSON` implementations and the like behave exactly the same regardless of the wire format
+//
+// NOTE: this is deliberate, the generated TypeScript types describe the JSON shape of the values (e.g. `[]byte` and `time.Time` are strings) so every codec has to produce that same shape
+
+// toGeneric converts any value into a tree of nil, bool, int64, uint64, float64, string, []any and map[string]any
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return withNativeNumbers(generic), nil
+}
+
+// withNativeNumbers replaces JSON numbers with the smallest Go type that holds them exactly, so that integers stay integers on the wire
+func withNativeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+
+		f, _ := v.Float64()
+		return f
+
+	case []any:
+		for i, item := range v {
+			v[i] = withNativeNumbers(item)
+		}
+
+	case map[string]any:
+		for key, item := range v {
+			v[key] = withNativeNumbers(item)
+		}
+	}
+
+	return v
+}
+
+// fromGeneric populates the value pointed to by v from a tree decoded by one of the binary codecs
+func fromGeneric(generic any, v any) error {
+	data, err := json.Marshal(withStringKeys(generic))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// withStringKeys converts maps with non-string keys (which both binary formats allow) into ones JSON can represent
+func withStringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = withStringKeys(item)
+		}
+		return m
+
+	case map[string]any:
+		for key, item := range v {
+			v[key] = withStringKeys(item)
+		}
+
+	case []any:
+		for i, item := range v {
+			v[i] = withStringKeys(item)
+		}
+	}
+
+	return v
+}
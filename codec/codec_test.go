@@ -0,0 +1,115 @@
+package codec_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"go.trulyao.dev/robin/codec"
+)
+
+type item struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Price    float64           `json:"price"`
+	Tags     []string          `json:"tags"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Archived bool              `json:"archived"`
+	Parent   *item             `json:"parent"`
+}
+
+func Test_RoundTrip(t *testing.T) {
+	value := item{
+		ID:    -42,
+		Name:  "A very long name that does not fit in a fixed-size string header",
+		Price: 19.99,
+		Tags:  []string{"a", "b"},
+		Meta:  map[string]string{"color": "red"},
+		Parent: &item{
+			ID:   1 << 40,
+			Name: "parent",
+		},
+	}
+
+	for _, c := range []codec.Codec{codec.JSON, codec.MessagePack, codec.CBOR} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			data, err := c.Marshal(value)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			var decoded item
+			if err := c.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+
+			if !reflect.DeepEqual(value, decoded) {
+				t.Errorf("expected %+v, got %+v", value, decoded)
+			}
+		})
+	}
+}
+
+func Test_KnownEncodings(t *testing.T) {
+	tests := []struct {
+		codec    codec.Codec
+		value    any
+		expected string
+	}{
+		{codec.MessagePack, map[string]any{"compact": true, "schema": 0}, "82a7636f6d70616374c3a6736368656d6100"},
+		{codec.MessagePack, []int{1, -1, 300}, "9301ffcd012c"},
+		{codec.CBOR, map[string]any{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{codec.CBOR, []any{-1, 1000000, "IETF", nil}, "84201a000f42406449455446f6"},
+	}
+
+	for _, test := range tests {
+		data, err := test.codec.Marshal(test.value)
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %v", test.value, err)
+		}
+
+		if got := hex.EncodeToString(data); got != test.expected {
+			t.Errorf("[%s] expected %s, got %s", test.codec.ContentType(), test.expected, got)
+		}
+	}
+}
+
+func Test_DecodeForeignEncodings(t *testing.T) {
+	tests := []struct {
+		codec    codec.Codec
+		data     string
+		expected any
+	}{
+		// Indefinite-length array and text string, and a half-precision float
+		{codec.CBOR, "9f7f626865626c6cfff93e00ff", []any{"hell", 1.5}},
+		// A uint64 and a float32
+		{codec.MessagePack, "92cf0000000000000001ca3fc00000", []any{float64(1), 1.5}},
+	}
+
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+
+		var decoded any
+		if err := test.codec.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("[%s] failed to unmarshal: %v", test.codec.ContentType(), err)
+		}
+
+		if !reflect.DeepEqual(decoded, test.expected) {
+			t.Errorf("[%s] expected %#v, got %#v", test.codec.ContentType(), test.expected, decoded)
+		}
+	}
+}
+
+func Test_DecodeMalformed(t *testing.T) {
+	deeplyNested := bytes.Repeat([]byte{0x91}, 2000)
+
+	for _, c := range []codec.Codec{codec.MessagePack, codec.CBOR} {
+		for _, data := range [][]byte{{0xdd, 0xff, 0xff, 0xff, 0xff}, deeplyNested, {0x01, 0x02}} {
+			var decoded any
+			if err := c.Unmarshal(data, &decoded); err == nil {
+				t.Errorf("[%s] expected an error for %x", c.ContentType(), data)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package codec
+
+import (
+	ugorji "github.com/ugorji/go/codec"
+)
+
+type msgpackCodec struct {
+	handle *ugorji.MsgpackHandle
+}
+
+func newMsgpackCodec() msgpackCodec {
+	handle := &ugorji.MsgpackHandle{WriteExt: true, PositiveIntUnsigned: true}
+	handle.Canonical = true
+	handle.RawToString = true
+	// Lengths come from untrusted input, so they are never trusted for allocations and nesting is bounded like the other codecs
+	handle.MaxInitLen = 1024
+	handle.MaxDepth = maxDepth
+
+	return msgpackCodec{handle: handle}
+}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (c msgpackCodec) Marshal(v any) ([]byte, error) {
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if err := ugorji.NewEncoderBytes(&out, c.handle).Encode(generic); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c msgpackCodec) Unmarshal(data []byte, v any) error {
+	var generic any
+
+	decoder := ugorji.NewDecoderBytes(data, c.handle)
+	if err := decoder.Decode(&generic); err != nil {
+		return err
+	}
+
+	if decoder.NumBytesRead() < len(data) {
+		return ErrTrailingData
+	}
+
+	return fromGeneric(generic, v)
+}
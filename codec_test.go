@@ -0,0 +1,77 @@
+package robin_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.trulyao.dev/robin"
+	"go.trulyao.dev/robin/codec"
+)
+
+func Test_ContentNegotiation(t *testing.T) {
+	type Payload struct {
+		Name string `json:"name"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("greet", func(ctx *robin.Context, body Payload) (string, error) {
+			return "Hello, " + body.Name, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		request     codec.Codec
+		accept      string
+		response    codec.Codec
+	}{
+		{"json by default", codec.JSON, "", codec.JSON},
+		{"responds with the request codec", codec.MessagePack, "", codec.MessagePack},
+		{"responds with the accepted codec", codec.JSON, "text/html, application/cbor;q=0.9", codec.CBOR},
+		{"falls back to the request codec for unknown accepted types", codec.CBOR, "text/html", codec.CBOR},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			body, err := test.request.Marshal(map[string]any{"d": Payload{Name: "John"}})
+			if err != nil {
+				t.Fatalf("failed to marshal payload: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/?__proc=q__greet", bytes.NewReader(body))
+			req.Header.Set("Content-Type", test.request.ContentType())
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if contentType := rec.Header().Get("Content-Type"); contentType != test.response.ContentType() {
+				t.Fatalf("expected content type %s, got %s", test.response.ContentType(), contentType)
+			}
+
+			var response struct {
+				Ok   bool   `json:"ok"`
+				Data string `json:"data"`
+			}
+			if err := test.response.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if !response.Ok || response.Data != "Hello, John" {
+				t.Errorf("unexpected response: %+v", response)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package robin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+	"go.trulyao.dev/robin/types"
+)
+
+type contextKey string
+
+func Test_ContextImplementsContext(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	lookup := func(ctx context.Context) (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		user, _ := ctx.Value(contextKey("user")).(string)
+		role, _ := ctx.Value("role").(string)
+		return user + ":" + role, nil
+	}
+
+	instance, err := r.
+		Add(robin.Query("whoami", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return lookup(ctx)
+		}).WithMiddleware(func(ctx *robin.Context) error {
+			ctx.WithValue(contextKey("user"), "john")
+			ctx.Set("role", "admin")
+			return nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	t.Run("values attached in middleware are visible to the procedure", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/?__proc=q__whoami", strings.NewReader(""))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if expected := `{"data":"john:admin","ok":true}`; rec.Body.String() != expected {
+			t.Errorf("expected %s, got %s", expected, rec.Body.String())
+		}
+	})
+
+	t.Run("cancellation is propagated from the request", func(t *testing.T) {
+		reqCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		req := httptest.NewRequestWithContext(reqCtx, http.MethodPost, "/?__proc=q__whoami", strings.NewReader(""))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if !strings.Contains(rec.Body.String(), `"ok":false`) {
+			t.Errorf("expected the call to fail, got %s", rec.Body.String())
+		}
+	})
+}
+
+func Test_ContextSetContextCycle(t *testing.T) {
+	ctx := types.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+	t.Run("accepts a context derived from the underlying context", func(t *testing.T) {
+		derived, cancel := context.WithCancel(ctx.Context())
+		defer cancel()
+
+		ctx.SetContext(derived)
+		cancel()
+
+		if ctx.Err() == nil {
+			t.Error("expected the context to be cancelled")
+		}
+	})
+
+	t.Run("panics on a context derived from the robin context itself", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected a panic, but there was none")
+			}
+		}()
+
+		ctx.SetContext(context.WithValue(ctx, contextKey("key"), "value"))
+	})
+}
@@ -1,47 +1,484 @@
 package robin
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
 
 	"go.trulyao.dev/robin/types"
 )
 
 type (
 	// A type that can be marshalled to JSON or simply a string
-	Serializable interface {
-		json.Marshaler
-	}
+	Serializable = types.Serializable
 
 	ErrorHandler func(error) (Serializable, int)
 
+	// See `types.ContextErrorHandler`
+	ContextErrorHandler = types.ContextErrorHandler
+
 	ErrorString string
+
+	// ErrorDetails is the response for errors that carry more than a message, i.e. a public code or metadata
+	ErrorDetails struct {
+		Message string         `json:"message"`
+		Code    string         `json:"code,omitempty"`
+		Meta    map[string]any `json:"meta,omitempty"`
+	}
+
+	// ErrorMapping describes how an error matched by an `ErrorRegistry` is reported to the client
+	ErrorMapping struct {
+		// The HTTP status code, 500 is used if it is not a valid error status code
+		Code int
+
+		// A stable, machine-readable code clients can switch on e.g. `user_not_found`
+		PublicCode string
+
+		// The message sent to the client, the status text of the code (e.g. "Not Found") is used if empty
+		//
+		// NOTE: the error's own message is never used since wrapped errors usually carry internal details in them (e.g. "loading user 1: sql: no rows in result set")
+		Message string
+	}
+
+	// ErrorRegistry maps sentinel errors and error types to status codes and public codes, errors anywhere in a wrapped chain are matched with `errors.Is` and `errors.As`
+	//
+	// Mappings are checked in the order they were registered and errors that are (or wrap) a `types.Error` are left as they are
+	ErrorRegistry struct {
+		mappings []registeredError
+	}
+
+	registeredError struct {
+		match   func(error) bool
+		mapping ErrorMapping
+	}
+
+	// ErrorFormat controls how error responses are rendered
+	ErrorFormat string
 )
 
-func DefaultErrorHandler(err error) (Serializable, int) {
+const (
+	// Errors are sent in the usual `{"ok": false, "error": ...}` envelope
+	ErrorFormatEnvelope ErrorFormat = "envelope"
+
+	// Errors are sent as RFC 9457 problem details documents, the details returned by the error handler become the `detail` member and extensions
+	ErrorFormatProblemJSON ErrorFormat = "problem+json"
+
+	ProblemJSONContentType = "application/problem+json"
+
+	// The code the generated client reports errors with when they are not one of the errors the procedure declares, so it cannot be used by declared errors
+	UnexpectedErrorCode = "unexpected"
+)
+
+// Members defined by RFC 9457 that extensions must not override
+var problemDetailsMembers = []string{"type", "title", "status", "detail", "instance"}
+
+// NewErrorRegistry creates an empty error registry, see `ErrorRegistry`
+func NewErrorRegistry() *ErrorRegistry {
+	return &ErrorRegistry{}
+}
+
+// Register maps errors that match the target with `errors.Is` (e.g. `sql.ErrNoRows`)
+func (er *ErrorRegistry) Register(target error, mapping ErrorMapping) *ErrorRegistry {
+	er.mappings = append(er.mappings, registeredError{
+		match:   func(err error) bool { return errors.Is(err, target) },
+		mapping: mapping,
+	})
+
+	return er
+}
+
+// RegisterErrorType maps errors of the type T with `errors.As` (e.g. `*pgconn.PgError`)
+func RegisterErrorType[T error](er *ErrorRegistry, mapping ErrorMapping) *ErrorRegistry {
+	er.mappings = append(er.mappings, registeredError{
+		match: func(err error) bool {
+			var target T
+			return errors.As(err, &target)
+		},
+		mapping: mapping,
+	})
+
+	return er
+}
+
+// Resolve returns the error the registered mapping for err describes, the original error is kept as its cause
+func (er *ErrorRegistry) Resolve(err error) (types.Error, bool) {
+	if er == nil || err == nil {
+		return types.Error{}, false
+	}
+
+	// Errors that already say how they should be reported take precedence
+	if _, ok := asError(err); ok {
+		return types.Error{}, false
+	}
+
+	for _, registered := range er.mappings {
+		if !registered.match(err) {
+			continue
+		}
+
+		code := registered.mapping.Code
+		if code < 400 || code >= 600 {
+			code = http.StatusInternalServerError
+		}
+
+		message := registered.mapping.Message
+		if message == "" {
+			message = http.StatusText(code)
+		}
+
+		return types.Error{Message: message, Code: code, PublicCode: registered.mapping.PublicCode, Cause: err}, true
+	}
+
+	return types.Error{}, false
+}
+
+// Handler returns an error handler that resolves errors with the registry before passing them on to the given handler
+func (er *ErrorRegistry) Handler(next ErrorHandler) ErrorHandler {
+	return func(err error) (Serializable, int) {
+		if resolved, ok := er.Resolve(err); ok {
+			return next(resolved)
+		}
+
+		return next(err)
+	}
+}
+
+// handleError resolves the error with the registry, redacts it in production mode and converts it into a response with the procedure's error handler or the global one
+func (r *Robin) handleError(ctx *Context, err error) (Serializable, int) {
+	if resolved, ok := r.errorRegistry.Resolve(err); ok {
+		err = resolved
+	}
+
+	procedure, found := r.findProcedure(ctx.ProcedureName(), ctx.ProcedureType())
+	if found {
+		if codedErr, ok := matchDeclaredError(procedure, err); ok {
+			err = declaredError{error: err, coded: codedErr}
+		}
+	}
+
+	if r.production && !isPublicError(err) {
+		err = redactError(ctx.Request(), ctx.ProcedureName(), err)
+	}
+
+	if found && procedure.ErrorHandler() != nil {
+		return procedure.ErrorHandler()(ctx, err)
+	}
+
+	return r.errorHandler(ctx, err)
+}
+
+// redactError logs the error with everything needed to track it down and replaces it with a generic error that only carries the ID it was logged with
+func redactError(req *http.Request, procedureName string, err error) error {
+	errorID := newErrorID()
+
+	attrs := []any{
+		slog.String("errorId", errorID),
+		slog.String("error", err.Error()),
+		slog.Any("causes", errorChain(err)),
+		slog.String("procedureName", procedureName),
+	}
+
+	if req != nil {
+		attrs = append(
+			attrs,
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("remoteAddr", req.RemoteAddr),
+			slog.String("userAgent", req.UserAgent()),
+		)
+	}
+
+	slog.Error("An internal error occurred", attrs...)
+
+	return types.Error{
+		Message:    "An internal error occurred",
+		Code:       http.StatusInternalServerError,
+		PublicCode: "internal_error",
+		Meta:       map[string]any{"errorId": errorID},
+	}
+}
+
+// isPublicError reports whether the details of the error (or any error it wraps) are safe to send to clients, errors the procedure declares are meant for clients so they always are
+//
+// NOTE: having an `ErrorCode` method is not enough, errors from other packages (e.g. SDK errors) often have one and their fields are not meant to be sent to clients
+func isPublicError(err error) bool {
+	var declared declaredError
+	if errors.As(err, &declared) {
+		return true
+	}
+
+	var publicErr types.PublicError
+	return errors.As(err, &publicErr) && publicErr.IsPublic()
+}
+
+// declaredError marks an error that matches one of the errors its procedure declares with `Errors`, only these are sent with their code and exported fields
+type declaredError struct {
+	error
+	coded types.CodedError
+}
+
+func (de declaredError) Unwrap() error { return de.error }
+
+func (de declaredError) ErrorCode() string { return de.coded.ErrorCode() }
+
+// matchDeclaredError finds the first coded error in the chain that has the same type or code as one of the errors the procedure declares
+func matchDeclaredError(procedure Procedure, err error) (types.CodedError, bool) {
+	declared := procedure.DeclaredErrors()
+	if len(declared) == 0 || err == nil {
+		return nil, false
+	}
+
+	if codedErr, ok := err.(types.CodedError); ok {
+		for _, d := range declared {
+			if reflect.TypeOf(d) == reflect.TypeOf(codedErr) || d.ErrorCode() == codedErr.ErrorCode() {
+				return codedErr, true
+			}
+		}
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return matchDeclaredError(procedure, e.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if codedErr, ok := matchDeclaredError(procedure, inner); ok {
+				return codedErr, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// warnUndeclaredError logs a warning if the procedure declares its errors but returned one that matches none of them, since the generated client can't know about it
+//
+// Invalid payloads are left out since every procedure can fail with them and the generated client already knows about them
+func warnUndeclaredError(procedure Procedure, err error) {
+	if len(procedure.DeclaredErrors()) == 0 {
+		return
+	}
+
+	if _, ok := matchDeclaredError(procedure, err); ok {
+		return
+	}
+
 	var (
-		code    = 500
-		message string
+		payloadErr    types.PayloadError
+		validationErr types.ValidationError
 	)
+	if errors.As(err, &payloadErr) || errors.As(err, &validationErr) {
+		return
+	}
 
-	switch e := err.(type) {
-	case types.Error:
-		message = e.Message
+	attrs := []any{
+		slog.String("procedureName", procedure.Name()),
+		slog.String("error", err.Error()),
+		slog.String("errorType", fmt.Sprintf("%T", err)),
+	}
+
+	var codedErr types.CodedError
+	if errors.As(err, &codedErr) {
+		attrs = append(attrs, slog.String("errorCode", codedErr.ErrorCode()))
+	}
+
+	slog.Warn("Procedure returned an error it does not declare, add it to the procedure with `Errors` to include it in the generated client", attrs...)
+}
+
+// errorChain returns the messages of the error and every error it wraps, in the order they are unwrapped
+func errorChain(err error) []string {
+	var chain []string
+
+	var walk func(err error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+
+		chain = append(chain, err.Error())
+
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+
+	walk(err)
+	return chain
+}
+
+// newErrorID generates a random ID used to find a redacted error in the logs
+func newErrorID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func DefaultErrorHandler(err error) (Serializable, int) {
+	if e, ok := asError(err); ok {
+		code := 500
 		if e.Code >= 400 && e.Code < 600 {
 			code = e.Code
 		}
 
-	case types.RobinError:
-		message = e.Reason
-		slog.Error("An internal error occurred", slog.String("reason", e.Reason), slog.Any("originalError", e.OriginalError.Error()))
+		if e.PublicCode == "" && len(e.Meta) == 0 {
+			return ErrorString(e.Message), code
+		}
+
+		return ErrorDetails{Message: e.Message, Code: e.PublicCode, Meta: e.Meta}, code
+	}
+
+	// Only errors the procedure declares are sent with their code and fields, any other coded error is treated like a plain error
+	var declared declaredError
+	if errors.As(err, &declared) {
+		code := http.StatusBadRequest
+		if withStatus, ok := declared.coded.(interface{ StatusCode() int }); ok && withStatus.StatusCode() >= 400 && withStatus.StatusCode() < 600 {
+			code = withStatus.StatusCode()
+		}
+
+		return codedErrorResponse{declared.coded}, code
+	}
+
+	// The error is returned as is so that clients get every offending field and not just the message
+	var payloadErr types.PayloadError
+	if errors.As(err, &payloadErr) {
+		return payloadErr, 400
+	}
+
+	var validationErr types.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr, 422
+	}
+
+	var robinErr types.RobinError
+	if errors.As(err, &robinErr) {
+		attrs := []any{slog.String("reason", robinErr.Reason)}
+		if robinErr.OriginalError != nil {
+			attrs = append(attrs, slog.String("originalError", robinErr.OriginalError.Error()))
+		}
+
+		slog.Error("An internal error occurred", attrs...)
+		return ErrorString(robinErr.Reason), 500
+	}
+
+	return ErrorString(err.Error()), 500
+}
+
+// makeProblemDetails converts the response from the error handler into an RFC 9457 problem details document
+//
+// A string response becomes the `detail`, for an object, its `message` becomes the `detail`, the entries in its `meta` (e.g. `types.Error.Meta`) are promoted to extensions and everything else is kept as an extension
+func makeProblemDetails(req *http.Request, errorResponse Serializable, status int) map[string]any {
+	title := http.StatusText(status)
+	if title == "" {
+		title = "Error"
+	}
+
+	// The original URI is preferred so that the instance still points at the full path when the handler is mounted under a prefix (e.g. with `http.StripPrefix`)
+	instance := req.RequestURI
+	if instance == "" {
+		instance = req.URL.RequestURI()
+	}
+
+	problem := map[string]any{
+		"type":     "about:blank",
+		"title":    title,
+		"status":   status,
+		"instance": instance,
+	}
+
+	addExtension := func(key string, value any) {
+		if !slices.Contains(problemDetailsMembers, key) {
+			problem[key] = value
+		}
+	}
+
+	var details any
+	if encoded, err := json.Marshal(errorResponse); err == nil {
+		_ = json.Unmarshal(encoded, &details)
+	}
+
+	switch d := details.(type) {
+	case nil:
+	case string:
+		problem["detail"] = d
+
+	case map[string]any:
+		if message, ok := d["message"].(string); ok {
+			problem["detail"] = message
+			delete(d, "message")
+		}
+
+		if meta, ok := d["meta"].(map[string]any); ok {
+			for key, value := range meta {
+				addExtension(key, value)
+			}
+			delete(d, "meta")
+		}
+
+		for key, value := range d {
+			addExtension(key, value)
+		}
 
 	default:
-		message = e.Error()
+		// Whatever the error handler returned is kept rather than dropped
+		problem["error"] = d
+	}
+
+	return problem
+}
+
+// asError finds the first `types.Error` in the chain, errors created with `types.NewError` are pointers so both forms are checked
+func asError(err error) (types.Error, bool) {
+	var ptr *types.Error
+	if errors.As(err, &ptr) && ptr != nil {
+		return *ptr, true
+	}
+
+	var value types.Error
+	if errors.As(err, &value) {
+		return value, true
 	}
 
-	return ErrorString(message), code
+	return types.Error{}, false
 }
 
 func (e ErrorString) MarshalJSON() ([]byte, error) {
 	return json.Marshal(string(e))
 }
+
+// codedErrorResponse sends a coded error with its exported fields, its code and its message
+type codedErrorResponse struct {
+	err types.CodedError
+}
+
+func (cr codedErrorResponse) MarshalJSON() ([]byte, error) {
+	response := make(map[string]any)
+
+	// Errors without exported fields (or that aren't structs) are sent with just their code and message
+	if encoded, err := json.Marshal(cr.err); err == nil {
+		_ = json.Unmarshal(encoded, &response)
+	}
+
+	if response == nil {
+		response = make(map[string]any)
+	}
+
+	response["code"] = cr.err.ErrorCode()
+	response["message"] = cr.err.Error()
+
+	return json.Marshal(response)
+}
+
+func (ed ErrorDetails) MarshalJSON() ([]byte, error) {
+	type details ErrorDetails
+	return json.Marshal(details(ed))
+}
@@ -0,0 +1,386 @@
+package robin_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+	"go.trulyao.dev/robin/types"
+)
+
+func Test_DefaultErrorHandler(t *testing.T) {
+	registry := robin.NewErrorRegistry().
+		Register(sql.ErrNoRows, robin.ErrorMapping{Code: 404, PublicCode: "not_found", Message: "Resource not found"})
+	robin.RegisterErrorType[*fs.PathError](registry, robin.ErrorMapping{Code: 400, PublicCode: "bad_path"})
+
+	handler := registry.Handler(robin.DefaultErrorHandler)
+
+	tests := []struct {
+		description string
+		err         error
+		code        int
+		body        string
+	}{
+		{"value error", types.Error{Message: "Forbidden", Code: 403}, 403, `"Forbidden"`},
+		{"pointer error", types.NewError("Not allowed", 401), 401, `"Not allowed"`},
+		{"wrapped pointer error", fmt.Errorf("checking access: %w", types.NewError("Not allowed", 401)), 401, `"Not allowed"`},
+		{
+			"error with public code and meta",
+			types.NewError("Too many requests", 429).WithPublicCode("rate_limited").WithMeta(map[string]any{"retryAfter": 30}),
+			429,
+			`{"message":"Too many requests","code":"rate_limited","meta":{"retryAfter":30}}`,
+		},
+		{"invalid status code", types.Error{Message: "Oops", Code: 200}, 500, `"Oops"`},
+		{"wrapped payload error", fmt.Errorf("decoding: %w", types.PayloadError{}), 400, `{"message":"Invalid payload provided","fields":[]}`},
+		{"robin error without original error", types.RobinError{Reason: "Something went wrong"}, 500, `"Something went wrong"`},
+		{"registered sentinel", fmt.Errorf("loading user 1: %w", sql.ErrNoRows), 404, `{"message":"Resource not found","code":"not_found"}`},
+		{
+			"registered type without a message",
+			&fs.PathError{Op: "open", Path: "/etc/secrets", Err: fs.ErrNotExist},
+			400,
+			`{"message":"Bad Request","code":"bad_path"}`,
+		},
+		{"explicit error wins over registry", types.NewError("Gone", 410).WithCause(sql.ErrNoRows), 410, `"Gone"`},
+		{"unknown error", errors.New("boom"), 500, `"boom"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			response, code := handler(test.err)
+			if code != test.code {
+				t.Errorf("expected status code %d, got %d", test.code, code)
+			}
+
+			body, err := json.Marshal(response)
+			if err != nil {
+				t.Fatalf("failed to marshal response: %v", err)
+			}
+
+			if string(body) != test.body {
+				t.Errorf("expected body %s, got %s", test.body, body)
+			}
+		})
+	}
+}
+
+func Test_ProductionMode(t *testing.T) {
+	for _, production := range []bool{false, true} {
+		r, err := robin.New(robin.Options{ProductionMode: production, TrapPanic: true})
+		if err != nil {
+			t.Fatalf("failed to create robin instance: %v", err)
+		}
+
+		instance, err := r.
+			Add(robin.Query("public", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				return "", types.NewError("Not allowed", 403)
+			})).
+			Add(robin.Query("internal", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				return "", fmt.Errorf("loading user: %w", sql.ErrConnDone)
+			})).
+			Add(robin.Query("panics", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				panic("something broke")
+			})).
+			Add(robin.Query("declared", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				return "", errTodoNotFound{ID: 1}
+			}).Errors(errTodoNotFound{})).
+			Add(robin.Query("undeclared", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				return "", fmt.Errorf("deleting todo: %w", errUpstream{RequestID: "req-123"})
+			}).Errors(errTodoNotFound{})).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build robin instance: %v", err)
+		}
+
+		tests := []struct {
+			procedure string
+			code      int
+			message   string
+			public    bool
+		}{
+			{"public", 403, "Not allowed", true},
+			{"internal", 500, "loading user: sql: connection is already closed", false},
+			{"panics", 500, "Panic trapped: something broke", false},
+			{"missing", 500, "Procedure `missing` (query) not found", false},
+			{"declared", 404, "Todo not found", true},
+			// Having an error code doesn't make an error public, only declaring it does
+			{"undeclared", 500, "deleting todo: Access denied by upstream", false},
+		}
+
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s (production: %v)", test.procedure, production), func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__"+test.procedure, strings.NewReader(""))
+				rec := httptest.NewRecorder()
+				instance.Handler()(rec, req)
+
+				if rec.Code != test.code {
+					t.Errorf("expected status code %d, got %d", test.code, rec.Code)
+				}
+
+				var response struct {
+					Error json.RawMessage `json:"error"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+
+				var message string
+				if err := json.Unmarshal(response.Error, &message); err == nil {
+					if production && !test.public {
+						t.Errorf("expected the error to be redacted, got %s", message)
+					} else if !strings.HasPrefix(message, test.message) {
+						t.Errorf("expected message %q, got %q", test.message, message)
+					}
+					return
+				}
+
+				var details robin.ErrorDetails
+				if err := json.Unmarshal(response.Error, &details); err != nil {
+					t.Fatalf("failed to decode error details: %v", err)
+				}
+
+				if !production || test.public {
+					if details.Message != test.message {
+						t.Errorf("expected message %q, got %q", test.message, details.Message)
+					}
+					return
+				}
+
+				if details.Code != "internal_error" || details.Meta["errorId"] == "" || strings.Contains(string(response.Error), "sql") || strings.Contains(string(response.Error), "req-123") {
+					t.Errorf("expected a generic error with an error ID, got %s", response.Error)
+				}
+			})
+		}
+	}
+}
+
+func Test_ProblemDetails(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("limited", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "", types.NewError("Too many requests", 429).WithPublicCode("rate_limited").WithMeta(map[string]any{"retryAfter": 30, "status": 200})
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	instance.AttachRestEndpoints(mux, &robin.RestApiOptions{Enable: true, ErrorFormat: robin.ErrorFormatProblemJSON})
+
+	// RPC calls keep the envelope since the format is only overridden for the RESTful endpoints
+	rec := httptest.NewRecorder()
+	instance.Handler()(rec, httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__limited", strings.NewReader("")))
+	if expected := `{"error":{"message":"Too many requests","code":"rate_limited","meta":{"retryAfter":30,"status":200}},"ok":false}`; rec.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/limited", nil))
+
+	if rec.Code != 429 {
+		t.Errorf("expected status code 429, got %d", rec.Code)
+	}
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != robin.ProblemJSONContentType {
+		t.Errorf("expected content type %s, got %s", robin.ProblemJSONContentType, contentType)
+	}
+
+	expected := `{"code":"rate_limited","detail":"Too many requests","instance":"/api/limited","retryAfter":30,"status":429,"title":"Too Many Requests","type":"about:blank"}`
+	if rec.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+	}
+}
+
+type (
+	errTodoNotFound struct {
+		ID int `json:"id"`
+	}
+
+	errTodoConflict struct{}
+
+	// errUpstream stands in for errors from other packages (e.g. SDK errors) that have a code but are not meant for clients
+	errUpstream struct {
+		RequestID string
+	}
+)
+
+func (e errTodoNotFound) Error() string     { return "Todo not found" }
+func (e errTodoNotFound) ErrorCode() string { return "not_found" }
+func (e errTodoNotFound) StatusCode() int   { return http.StatusNotFound }
+
+func (e errTodoConflict) Error() string     { return "Todo already exists" }
+func (e errTodoConflict) ErrorCode() string { return "conflict" }
+
+func (e errUpstream) Error() string     { return "Access denied by upstream" }
+func (e errUpstream) ErrorCode() string { return "AccessDenied" }
+
+func Test_DeclaredErrors(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("getTodo", func(ctx *robin.Context, id int) (string, error) {
+			if id == 0 {
+				return "", errTodoConflict{}
+			}
+
+			return "", fmt.Errorf("loading todo: %w", errTodoNotFound{ID: id})
+		}).Errors(errTodoNotFound{}, errTodoConflict{})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		payload string
+		code    int
+		body    string
+	}{
+		{"1", http.StatusNotFound, `{"error":{"code":"not_found","id":1,"message":"Todo not found"},"ok":false}`},
+		{"0", http.StatusBadRequest, `{"error":{"code":"conflict","message":"Todo already exists"},"ok":false}`},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__getTodo", strings.NewReader(`{"d":`+test.payload+`}`))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("expected status code %d, got %d", test.code, rec.Code)
+		}
+
+		if rec.Body.String() != test.body {
+			t.Errorf("expected body %s, got %s", test.body, rec.Body.String())
+		}
+	}
+
+	r, err = robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	_, err = r.
+		Add(robin.Query("getTodo", func(ctx *robin.Context, id int) (string, error) { return "", nil }).Errors(errTodoNotFound{}, errTodoNotFound{})).
+		Build()
+	if err == nil {
+		t.Errorf("expected building with duplicate error codes to fail")
+	}
+}
+
+func Test_UndeclaredErrorWarning(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	r, err := robin.New(robin.Options{EnableDebugMode: true})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("getTodo", func(ctx *robin.Context, id int) (string, error) {
+			switch id {
+			case 1:
+				return "", errTodoNotFound{ID: id}
+			case 2:
+				return "", types.NewError("Todo is archived", http.StatusGone)
+			default:
+				return "", errors.New("database is down")
+			}
+		}).Errors(errTodoNotFound{})).
+		Add(robin.Query("listTodos", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "", errors.New("database is down")
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		procedure string
+		payload   string
+		warns     bool
+	}{
+		{"getTodo", "1", false},
+		{"getTodo", "2", true},
+		{"getTodo", "3", true},
+		// Procedures that don't declare any errors are left alone
+		{"listTodos", "null", false},
+	}
+
+	for _, test := range tests {
+		logs.Reset()
+
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__"+test.procedure, strings.NewReader(`{"d":`+test.payload+`}`))
+		instance.Handler()(httptest.NewRecorder(), req)
+
+		if warned := strings.Contains(logs.String(), "does not declare"); warned != test.warns {
+			t.Errorf("[%s(%s)] expected warning: %t, got %t (%s)", test.procedure, test.payload, test.warns, warned, logs.String())
+		}
+	}
+}
+
+func Test_ContextErrorHandler(t *testing.T) {
+	r, err := robin.New(robin.Options{
+		ContextErrorHandler: func(ctx *robin.Context, err error) (robin.Serializable, int) {
+			return robin.ErrorString(fmt.Sprintf("%s (%s, request %s)", err.Error(), ctx.ProcedureName(), ctx.Header("X-Request-ID"))), 400
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	fail := func(ctx *robin.Context, _ robin.Void) (string, error) {
+		return "", errors.New("Something went wrong")
+	}
+
+	instance, err := r.
+		Add(robin.Query("list", fail)).
+		Add(robin.Query("admin.list", fail).WithErrorHandler(func(ctx *robin.Context, err error) (robin.Serializable, int) {
+			return robin.ErrorString("Admin: " + err.Error()), 500
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		procedure string
+		code      int
+		body      string
+	}{
+		{"list", 400, `{"error":"Something went wrong (list, request abc)","ok":false}`},
+		{"admin.list", 500, `{"error":"Admin: Something went wrong","ok":false}`},
+		{"missing", 400, `{"error":"Procedure ` + "`missing`" + ` (query) not found, did you mean ` + "`list`" + ` (query)? (missing, request abc)","ok":false}`},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__"+test.procedure, strings.NewReader(""))
+		req.Header.Set("X-Request-ID", "abc")
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("expected status code %d, got %d", test.code, rec.Code)
+		}
+
+		if rec.Body.String() != test.body {
+			t.Errorf("expected body %s, got %s", test.body, rec.Body.String())
+		}
+	}
+}
@@ -27,7 +27,7 @@ func NewError(message string, code int) *Error {
 
 type User struct {
 	ID   int    `json:"id,omitempty"`
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required,min=2"`
 }
 
 var users = []User{
@@ -160,11 +160,8 @@ func getUsersByIds(_ *robin.Context, ids []int) ([]User, error) {
 	return foundUsers, nil
 }
 
+// The `validate` tags on User are checked before this is called, so the name is always present here
 func addUser(_ *robin.Context, user User) (User, error) {
-	if user.Name == "" {
-		return User{}, Error{Message: "User name is required", Code: 400}
-	}
-
 	user.ID = len(users) + 1
 	users = append(users, user)
 
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -16,6 +17,7 @@ import (
 	"golang.org/x/text/language"
 
 	"go.trulyao.dev/robin/generator/templates"
+	"go.trulyao.dev/robin/internal/validation"
 	"go.trulyao.dev/robin/types"
 )
 
@@ -28,8 +30,9 @@ type (
 	_RobinSchema struct{}
 
 	_RobinExport struct {
-		Queries   _RobinSchema `mirror:"name:queries"`
-		Mutations _RobinSchema `mirror:"name:mutations"`
+		Queries       _RobinSchema `mirror:"name:queries"`
+		Mutations     _RobinSchema `mirror:"name:mutations"`
+		Subscriptions _RobinSchema `mirror:"name:subscriptions"`
 	}
 )
 
@@ -52,11 +55,20 @@ type (
 		// The generated mutation methods
 		MutationMethods string
 
+		// The generated subscription methods
+		SubscriptionMethods string
+
 		// Whether to use the union result type or not - when enabled, the result type will be a uniion of the Ok and Error types which would disallow access to any of the fields without checking the `ok` field first
 		UseUnionResult bool
 
 		// Whether to throw a ProcedureCallError when a procedure call fails for any reason (e.g. invalid payload, user-defined error, etc.) instead of returning an error result
 		ThrowOnError bool
+
+		// The validation rules of the payloads of all procedures as a JSON object, keyed by procedure type and then name
+		ValidationSchemas string
+
+		// The codes of the errors declared by all procedures as a JSON object, keyed by procedure type and then name
+		DeclaredErrorCodes string
 	}
 
 	MethodTemplateOpts struct {
@@ -86,8 +98,9 @@ type (
 	}
 
 	GeneratedMethods struct {
-		Mutations []string
-		Queries   []string
+		Mutations     []string
+		Queries       []string
+		Subscriptions []string
 	}
 )
 
@@ -114,14 +127,27 @@ func (g *generator) GenerateBindings(opts GenerateBindingsOpts) (string, error)
 		return "", fmt.Errorf("failed to generate methods: %w", err)
 	}
 
+	validationSchemas, err := g.GenerateValidationSchemas()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate validation schemas: %w", err)
+	}
+
+	declaredErrorCodes, err := g.GenerateDeclaredErrorCodes()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate declared error codes: %w", err)
+	}
+
 	var builder strings.Builder
 	if err := bindingsTemplate.Execute(&builder, TemplateOpts{
-		IncludeSchema:   opts.IncludeSchema,
-		Schema:          strings.TrimSpace(opts.Schema),
-		MutationMethods: strings.Join(methods.Mutations, "\n"),
-		QueryMethods:    strings.Join(methods.Queries, "\n"),
-		UseUnionResult:  opts.UseUnionResult,
-		ThrowOnError:    opts.ThrowOnError,
+		IncludeSchema:       opts.IncludeSchema,
+		Schema:              strings.TrimSpace(opts.Schema),
+		MutationMethods:     strings.Join(methods.Mutations, "\n"),
+		QueryMethods:        strings.Join(methods.Queries, "\n"),
+		SubscriptionMethods: strings.Join(methods.Subscriptions, "\n"),
+		UseUnionResult:      opts.UseUnionResult,
+		ThrowOnError:        opts.ThrowOnError,
+		ValidationSchemas:   validationSchemas,
+		DeclaredErrorCodes:  declaredErrorCodes,
 	}); err != nil {
 		return "", fmt.Errorf("failed to execute bindings template: %w", err)
 	}
@@ -129,8 +155,31 @@ func (g *generator) GenerateBindings(opts GenerateBindingsOpts) (string, error)
 	return builder.String(), nil
 }
 
+// Subscriptions are callback-based rather than promise-based, so they get a different method template
+const subscriptionMethodTemplate = `
+  /**
+   * @procedure {{ .OriginalName }}
+   *
+   * @returns Unsubscribe a function to call to end the subscription
+   **/
+  {{.Name}}({{ if .HasPayload }}payload: PayloadOf<CSchema, "subscription", {{ printf "%q" .OriginalName }}>, {{end}}onData: (data: ResultOf<CSchema, "subscription", {{ printf "%q" .OriginalName }}>) => void, opts?: SubscribeOpts): Unsubscribe {
+    return this.client.subscribe({ ...opts, name: {{ printf "%q" .OriginalName }}, payload: {{ if .HasPayload }}payload{{else}}undefined{{end}}, onData });
+  }`
+
+// Queries and mutations that return a channel or an iterator are streamed, so they return an async iterable instead of a promise
+const streamMethodTemplate = `
+  /**
+   * @procedure {{ .OriginalName }}
+   *
+   * @returns AsyncIterable<ResultOf<CSchema, {{ printf "%q" .Type }}, {{ printf "%q" .OriginalName }}>> values are produced as they are streamed by the server
+   * @throws {ProcedureCallError} if the procedure call fails or an error occurs mid-stream
+   **/
+  {{.Name}}({{ if .HasPayload }}payload: PayloadOf<CSchema, {{ printf "%q" .Type }}, {{ printf "%q" .OriginalName }}>, {{end}}opts?: StreamOpts): AsyncIterable<ResultOf<CSchema, {{ printf "%q" .Type }}, {{ printf "%q" .OriginalName }}>> {
+    return this.client.stream({{ printf "%q" .Type }}, { ...opts, name: {{ printf "%q" .OriginalName }}, payload: {{ if .HasPayload }}payload{{else}}undefined{{end}} });
+  }`
+
 func (g *generator) GenerateMethods(opts GenerateMethodsOpts) (*GeneratedMethods, error) {
-	var mutations, queries []string
+	var mutations, queries, subscriptions []string
 
 	for _, procedure := range g.procedures {
 		methodTemplate := `
@@ -150,10 +199,17 @@ func (g *generator) GenerateMethods(opts GenerateMethodsOpts) (*GeneratedMethods
 			procedureType = "query"
 		case types.ProcedureTypeMutation:
 			procedureType = "mutation"
+		case types.ProcedureTypeSubscription:
+			procedureType = "subscription"
+			methodTemplate = subscriptionMethodTemplate
 		default: // This should never happen
 			return &GeneratedMethods{}, fmt.Errorf("unknown procedure type: %s", procedure.Type())
 		}
 
+		if procedure.Type() != types.ProcedureTypeSubscription && procedure.IsStreaming() {
+			methodTemplate = streamMethodTemplate
+		}
+
 		opts := MethodTemplateOpts{
 			OriginalName: procedure.Name(),
 			Name:         NormalizeProcedureName(procedure.Name()),
@@ -172,14 +228,71 @@ func (g *generator) GenerateMethods(opts GenerateMethodsOpts) (*GeneratedMethods
 			return &GeneratedMethods{}, fmt.Errorf("failed to execute method template: %w", err)
 		}
 
-		if procedure.Type() == types.ProcedureTypeQuery {
+		switch procedure.Type() {
+		case types.ProcedureTypeQuery:
 			queries = append(queries, methodBuilder.String())
-		} else {
+		case types.ProcedureTypeMutation:
 			mutations = append(mutations, methodBuilder.String())
+		case types.ProcedureTypeSubscription:
+			subscriptions = append(subscriptions, methodBuilder.String())
 		}
 	}
 
-	return &GeneratedMethods{Queries: queries, Mutations: mutations}, nil
+	return &GeneratedMethods{Queries: queries, Mutations: mutations, Subscriptions: subscriptions}, nil
+}
+
+// GenerateValidationSchemas exports the rules declared in the `validate` tags of the payloads as a JSON object, procedures without any rules are left out
+func (g *generator) GenerateValidationSchemas() (string, error) {
+	schemas := map[string]map[string]*validation.Schema{
+		string(types.ProcedureTypeQuery):        {},
+		string(types.ProcedureTypeMutation):     {},
+		string(types.ProcedureTypeSubscription): {},
+	}
+
+	for _, procedure := range g.procedures {
+		// Raw payloads are never decoded, so they are never validated either
+		if procedure.ExpectedPayloadType() != types.ExpectedPayloadDecoded {
+			continue
+		}
+
+		schema, err := validation.For(reflect.TypeOf(procedure.NewPayload()).Elem())
+		if err != nil {
+			return "", fmt.Errorf("failed to read validation rules for procedure %s: %w", procedure.Name(), err)
+		}
+
+		if schema != nil {
+			schemas[string(procedure.Type())][procedure.Name()] = schema
+		}
+	}
+
+	exported, err := json.Marshal(schemas)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal validation schemas: %w", err)
+	}
+
+	return string(exported), nil
+}
+
+// GenerateDeclaredErrorCodes exports the codes of the errors each procedure declares as a JSON object, procedures that don't declare any are left out
+func (g *generator) GenerateDeclaredErrorCodes() (string, error) {
+	codes := map[string]map[string][]string{
+		string(types.ProcedureTypeQuery):        {},
+		string(types.ProcedureTypeMutation):     {},
+		string(types.ProcedureTypeSubscription): {},
+	}
+
+	for _, procedure := range g.procedures {
+		for _, declared := range procedure.DeclaredErrors() {
+			codes[string(procedure.Type())][procedure.Name()] = append(codes[string(procedure.Type())][procedure.Name()], declared.ErrorCode())
+		}
+	}
+
+	exported, err := json.Marshal(codes)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal declared error codes: %w", err)
+	}
+
+	return string(exported), nil
 }
 
 // Generates the typescript schema for the given procedures
@@ -221,7 +334,7 @@ func (g *generator) onParseItem(sourceName string, target parser.Item) error {
 func (g *generator) handleClientType(item *parser.Struct) error {
 	item.ItemName = "Schema"
 
-	queries, mutations, err := g.getProcedureFields(item)
+	queries, mutations, subscriptions, err := g.getProcedureFields(item)
 	if err != nil {
 		return err
 	}
@@ -246,6 +359,34 @@ func (g *generator) handleClientType(item *parser.Struct) error {
 			)
 		}
 
+		// Declared errors are keyed by their codes, the client turns these into a union discriminated by the code
+		errorsItem := &parser.Struct{ItemName: procedure.Name() + "Errors"}
+		for _, declared := range procedure.DeclaredErrors() {
+			errorType := reflect.TypeOf(declared)
+			for errorType.Kind() == reflect.Pointer {
+				errorType = errorType.Elem()
+			}
+
+			// Only the exported fields of structs are sent along with the code and message
+			var errorItem parser.Item = &parser.Struct{ItemName: errorType.Name()}
+			if errorType.Kind() == reflect.Struct {
+				if errorItem, err = g.mirrorInstance.Parser().Parse(errorType); err != nil {
+					return fmt.Errorf(
+						"failed to parse error `%s` for procedure %s: %w",
+						declared.ErrorCode(),
+						procedure.Name(),
+						err,
+					)
+				}
+			}
+
+			errorsItem.Fields = append(errorsItem.Fields, parser.Field{
+				ItemName: declared.ErrorCode(),
+				BaseItem: errorItem,
+				Meta:     meta.Meta{Name: fmt.Sprintf(`"%s"`, declared.ErrorCode())},
+			})
+		}
+
 		procedureField := parser.Field{
 			ItemName: procedure.Name(),
 			BaseItem: &parser.Struct{
@@ -256,6 +397,9 @@ func (g *generator) handleClientType(item *parser.Struct) error {
 
 					// Payload
 					{ItemName: "payload", BaseItem: payloadItem},
+
+					// Declared errors
+					{ItemName: "errors", BaseItem: errorsItem},
 				},
 			},
 			Meta: meta.Meta{
@@ -269,39 +413,52 @@ func (g *generator) handleClientType(item *parser.Struct) error {
 
 		case types.ProcedureTypeMutation:
 			mutations.Fields = append(mutations.Fields, procedureField)
+
+		// The result of a subscription is the type of each event pushed to the subscriber
+		case types.ProcedureTypeSubscription:
+			subscriptions.Fields = append(subscriptions.Fields, procedureField)
 		}
 	}
 
 	return nil
 }
 
-// WARNING: please do not refactor this to use implicit returns even though the function signature allows it (naked return), the signature here is used as some form of documentation since the first two	return values are the same type
+// WARNING: please do not refactor this to use implicit returns even though the function signature allows it (naked return), the signature here is used as some form of documentation since the first three	return values are the same type
 func (g *generator) getProcedureFields(
 	schema *parser.Struct,
-) (queries *parser.Struct, mutations *parser.Struct, err error) {
+) (queries *parser.Struct, mutations *parser.Struct, subscriptions *parser.Struct, err error) {
 	// Attempt to get the fields
 	queriesField, exists := schema.GetField("queries")
 	if !exists {
-		return nil, nil, unexpectedErr("missing queries field in Export struct")
+		return nil, nil, nil, unexpectedErr("missing queries field in Export struct")
 	}
 
 	mutationsField, exists := schema.GetField("mutations")
 	if !exists {
-		return nil, nil, unexpectedErr("missing mutations field in Export struct")
+		return nil, nil, nil, unexpectedErr("missing mutations field in Export struct")
+	}
+
+	subscriptionsField, exists := schema.GetField("subscriptions")
+	if !exists {
+		return nil, nil, nil, unexpectedErr("missing subscriptions field in Export struct")
 	}
 
 	var ok bool
 
 	// Attempt to get a reference to base item's fields
 	if queries, ok = queriesField.BaseItem.(*parser.Struct); !ok {
-		return nil, nil, unexpectedErr("`queries` field in `Export` struct is not a struct")
+		return nil, nil, nil, unexpectedErr("`queries` field in `Export` struct is not a struct")
 	}
 
 	if mutations, ok = mutationsField.BaseItem.(*parser.Struct); !ok {
-		return nil, nil, unexpectedErr("`mutations` field in `Export` struct is not a struct")
+		return nil, nil, nil, unexpectedErr("`mutations` field in `Export` struct is not a struct")
+	}
+
+	if subscriptions, ok = subscriptionsField.BaseItem.(*parser.Struct); !ok {
+		return nil, nil, nil, unexpectedErr("`subscriptions` field in `Export` struct is not a struct")
 	}
 
-	return queries, mutations, nil
+	return queries, mutations, subscriptions, nil
 }
 
 // NormalizeProcedureName normalizes the procedure name to a valid typescript function name
@@ -1,9 +1,12 @@
 package generator_test
 
 import (
+	"strings"
 	"testing"
 
+	"go.trulyao.dev/robin"
 	"go.trulyao.dev/robin/generator"
+	"go.trulyao.dev/robin/types"
 )
 
 func Test_TestNormalizeName(t *testing.T) {
@@ -30,3 +33,31 @@ func Test_TestNormalizeName(t *testing.T) {
 		})
 	}
 }
+
+func Test_ValidationSchemasOfMaps(t *testing.T) {
+	type Extra struct {
+		Meta map[string]int `json:"meta"`
+	}
+
+	type Settings struct {
+		Labels map[string]string `json:"labels" validate:"required"`
+		Extra  Extra             `json:"extra" validate:"required"`
+	}
+
+	g := generator.New([]types.Procedure{
+		robin.Mutation("settings", func(ctx *robin.Context, settings Settings) (int, error) {
+			return len(settings.Labels), nil
+		}),
+	})
+
+	schemas, err := g.GenerateValidationSchemas()
+	if err != nil {
+		t.Fatalf("failed to generate validation schemas: %v", err)
+	}
+
+	// The client needs to know which objects are maps to agree with the server on whether they are empty
+	expected := `"settings":{"kind":"object","fields":{"extra":{"kind":"object","rules":[{"rule":"required"}],"fields":{"meta":{"kind":"map"}}},"labels":{"kind":"map","rules":[{"rule":"required"}]}}}`
+	if !strings.Contains(schemas, expected) {
+		t.Errorf("expected the schemas to contain %s, got %s", expected, schemas)
+	}
+}
@@ -1,93 +1,183 @@
 package robin
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 
 	"go.trulyao.dev/robin/internal/guarded"
+	"go.trulyao.dev/robin/internal/validation"
 	"go.trulyao.dev/robin/types"
 )
 
-// handleProcedureCall handles a procedure call, calling the procedure and returning the result from the handler
+// handleProcedureCall handles a procedure call, calling the procedure and writing the result from the handler to the response
 func (r *Robin) handleProcedureCall(ctx *Context, procedure Procedure) error {
+	result, err := r.callProcedure(ctx, procedure)
+	if err != nil {
+		return err
+	}
+
+	// Procedures that return a channel or an iterator are streamed to the caller as they produce values instead of being buffered
+	if stream, ok := result.(types.Stream); ok {
+		return r.writeNDJSONStream(ctx, stream)
+	}
+
+	response := map[string]any{"ok": true, "data": result}
+
+	c := r.responseCodec(ctx.Request())
+	encodedResponse, err := c.Marshal(response)
+	if err != nil {
+		return RobinError{Reason: "Failed to marshal response", OriginalError: err}
+	}
+
+	ctx.Response().Header().Set("Content-Type", c.ContentType())
+	ctx.Response().WriteHeader(200)
+	if _, err := ctx.Response().Write(encodedResponse); err != nil {
+		slog.Error("Failed to write response", slog.String("error", err.Error()))
+	}
+
+	return nil
+}
+
+// callProcedure executes the procedure's middleware chain, decodes the payload and calls the procedure, returning the result without writing anything to the response
+//
+// The call is bounded by the procedure's timeout (or the default timeout) if there is one, and call-scoped services are cleaned up once it is done
+func (r *Robin) callProcedure(ctx *Context, procedure Procedure) (any, error) {
+	result, err := r.withServiceScope(ctx, func() (any, error) {
+		if timeout := r.procedureTimeout(ctx, procedure); timeout > 0 {
+			return r.runProcedureWithTimeout(ctx, procedure, timeout)
+		}
+
+		return r.runProcedure(ctx, procedure)
+	})
+
+	if err != nil && r.debug {
+		warnUndeclaredError(procedure, err)
+	}
+
+	return result, err
+}
+
+// runProcedure does the actual work for `callProcedure`, the around middleware wrap everything else
+func (r *Robin) runProcedure(ctx *Context, procedure Procedure) (any, error) {
+	// The body can only be read once, so the payload is decoded once even if an around middleware calls `next` multiple times (e.g. to retry)
+	decode := sync.OnceValues(func() (any, error) {
+		return r.decodePayload(ctx, procedure)
+	})
+
+	next := types.Next(func() (any, error) {
+		return r.executeProcedure(ctx, procedure, decode)
+	})
+
+	// Build the chain from the inside out so that the first middleware is the outermost
+	aroundMiddleware := procedure.AroundMiddlewareFns()
+	for i := len(aroundMiddleware) - 1; i >= 0; i-- {
+		middleware, inner := aroundMiddleware[i], next
+		next = func() (any, error) {
+			return middleware(ctx, inner)
+		}
+	}
+
+	result, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return Void{}, nil
+	}
+
+	return result, nil
+}
+
+// executeProcedure runs the middleware functions, decodes the payload, runs the guards and finally calls the procedure
+func (r *Robin) executeProcedure(ctx *Context, procedure Procedure, decode func() (any, error)) (any, error) {
 	// Call the procedure middleware functions before we proceed to to any work
 	for _, middleware := range procedure.MiddlewareFns() {
 		if err := middleware(ctx); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	var (
-		data = struct {
-			Payload any `json:"d"`
-		}{
-			Payload: procedure.PayloadInterface(),
+	payload, err := decode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Guards get the decoded value rather than the pointer it was decoded into
+	if guards := procedure.Guards(); len(guards) > 0 {
+		guardPayload := payload
+		if procedure.ExpectedPayloadType() == types.ExpectedPayloadDecoded {
+			guardPayload = reflect.ValueOf(payload).Elem().Interface()
 		}
 
-		response = make(map[string]any)
-	)
+		for _, guard := range guards {
+			if err := guard(ctx, guardPayload); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	response["ok"] = true
-	response["data"] = Void{}
+	// Call the procedure
+	return procedure.Call(ctx, payload)
+}
 
-	// Decode the request body into the "typeless" payload field of the data struct
+// decodePayload reads the payload the procedure expects from the request
+func (r *Robin) decodePayload(ctx *Context, procedure Procedure) (any, error) {
 	switch procedure.ExpectedPayloadType() {
 	case types.ExpectedPayloadDecoded:
-		// Decode the request body into the payload field of the data struct
-		if err := json.NewDecoder(ctx.Request().Body).Decode(&data); err != nil {
-			defer ctx.Request().Body.Close()
+		defer ctx.Request().Body.Close()
+
+		body, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return nil, types.Error{Message: "Failed to read request body", Code: http.StatusBadRequest, Cause: err}
+		}
 
-			if r.debug && err.Error() != "EOF" {
-				slog.Error("Failed to decode request body", slog.String("error", err.Error()))
+		// The payload is decoded straight into the type the procedure expects, an empty body leaves it as the zero value
+		payload := procedure.NewPayload()
+		if len(bytes.TrimSpace(body)) > 0 {
+			// encoding/json decodes into the value a non-nil pointer in an interface points to, so this fills in the payload directly
+			data := struct {
+				Payload any `json:"d"`
+			}{
+				Payload: payload,
 			}
 
-			// If the error is EOF, it means that the request body was empty, so we set the payload to Void
-			if err.Error() == "EOF" {
-				data.Payload = Void{}
-			} else {
-				if err = guarded.MakeCastError(procedure.PayloadInterface(), data.Payload); err != nil {
-					return err
+			c := r.requestCodec(ctx.Request())
+			if err := c.Unmarshal(body, &data); err != nil {
+				if r.debug {
+					slog.Error("Failed to decode request body", slog.String("error", err.Error()))
 				}
+
+				return nil, makeDecodeError(c, body, procedure, err)
 			}
 		}
 
+		// Rules declared in `validate` tags are checked before anything else sees the payload
+		if err := validation.Validate(payload); err != nil {
+			return nil, err
+		}
+
+		return payload, nil
+
 	case types.ExpectedPayloadRaw:
 		// If the procedure expects a raw payload, we set the payload to the raw request body
-		data.Payload = ctx.Request().Body
+		return ctx.Request().Body, nil
 
 	case types.ExpectedPayloadNone:
 		fallthrough
 	default:
 		// If the procedure doesn't expect a payload, we set the payload to Void
-		data.Payload = Void{}
-	}
-
-	// Call the procedure
-	result, err := procedure.Call(ctx, data.Payload)
-	if err != nil {
-		return err
-	}
-
-	if result != nil {
-		response["data"] = result
+		return Void{}, nil
 	}
-
-	strResponse, err := json.Marshal(response)
-	if err != nil {
-		return RobinError{Reason: "Failed to marshal response", OriginalError: err}
-	}
-
-	ctx.Response().Header().Add("content-type", "application/json")
-	ctx.Response().WriteHeader(200)
-	if _, err := ctx.Response().Write(strResponse); err != nil {
-		slog.Error("Failed to write response", slog.String("error", err.Error()))
-	}
-
-	return nil
 }
 
 // handleProcedureCallFromURL handles a procedure call from a URL
@@ -97,7 +187,8 @@ func (r *Robin) getProcedureMetaFromURL(url *url.URL) (ProcedureType, string, er
 		procedureType ProcedureType
 	)
 
-	// Queries can only be issued via GET requests, and Mutations can only be issued via POST requests but in both cases, the procedure name is attached to the URL query
+	// Queries can be issued via GET (with the payload URL-encoded in the `d` query parameter) or POST requests, and Mutations can only be issued via POST requests but in both cases, the procedure name is attached to the URL query
+	// Subscriptions are issued via GET or POST requests and kept open for the server to push events
 	proc := url.Query().Get(ProcNameKey)
 	if strings.TrimSpace(proc) == "" {
 		return "", "", errors.New("no procedure name provided")
@@ -106,7 +197,7 @@ func (r *Robin) getProcedureMetaFromURL(url *url.URL) (ProcedureType, string, er
 	procParts := strings.Split(proc, ProcSeparator)
 	if len(procParts) != 2 {
 		return "", "", fmt.Errorf(
-			"invalid procedure param provided in URL, expected format (q|m)%s[name] e.g q%sgetUser",
+			"invalid procedure param provided in URL, expected format (q|m|s)%s[name] e.g q%sgetUser",
 			ProcSeparator,
 			ProcSeparator,
 		)
@@ -119,6 +210,8 @@ func (r *Robin) getProcedureMetaFromURL(url *url.URL) (ProcedureType, string, er
 		procedureType = ProcedureTypeQuery
 	case "m":
 		procedureType = ProcedureTypeMutation
+	case "s":
+		procedureType = ProcedureTypeSubscription
 	default:
 		return "", "", errors.New("no procedure name provided")
 	}
@@ -126,6 +219,47 @@ func (r *Robin) getProcedureMetaFromURL(url *url.URL) (ProcedureType, string, er
 	return procedureType, procedureName, nil
 }
 
+// makeRequestFromURLPayload creates a copy of a GET request with the JSON payload in the URL query moved into the body, so that the procedure can decode it as usual
+func (r *Robin) makeRequestFromURLPayload(req *http.Request, procedure Procedure) (*http.Request, error) {
+	// Mutations change state, so they must never be issued via GET requests which are expected to be safe to cache and retry
+	if procedure.Type() == ProcedureTypeMutation {
+		return nil, types.Error{
+			Message: fmt.Sprintf("Mutation `%s` cannot be called via a GET request, use POST instead", procedure.Name()),
+			Code:    http.StatusMethodNotAllowed,
+		}
+	}
+
+	payload := req.URL.Query().Get(PayloadKey)
+	if payload != "" && !json.Valid([]byte(payload)) {
+		return nil, types.Error{
+			Message: fmt.Sprintf("Invalid payload provided in the `%s` query parameter, expected a URL-encoded JSON value", PayloadKey),
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	// The payload is wrapped in the usual envelope even for procedures expecting a raw payload, so that they get the same body as they would over POST
+	return newPayloadRequest(req, json.RawMessage(payload)), nil
+}
+
+// makeDecodeError converts an error from decoding the request body into a client error, payloads that are well-formed but don't match the expected type produce a `PayloadError` listing every offending value
+func makeDecodeError(c Codec, body []byte, procedure Procedure, err error) error {
+	var raw struct {
+		Payload any `json:"d"`
+	}
+
+	if rawErr := c.Unmarshal(body, &raw); rawErr != nil {
+		return types.Error{Message: "Malformed payload provided", Code: http.StatusBadRequest, Cause: err}
+	}
+
+	fields := guarded.CheckPayload(reflect.TypeOf(procedure.NewPayload()).Elem(), raw.Payload)
+	if len(fields) == 0 {
+		// Whatever failed is beyond what we can check (e.g. a custom `UnmarshalJSON` rejected the value)
+		return types.Error{Message: "Invalid payload provided", Code: http.StatusBadRequest, Cause: err}
+	}
+
+	return types.PayloadError{Fields: fields}
+}
+
 // findProcedure finds a procedure by name and type in the Robin instance
 // An instance can have multiple procedures with the same name but different types
 func (r *Robin) findProcedure(name string, procedureType ProcedureType) (Procedure, bool) {
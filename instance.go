@@ -1,14 +1,18 @@
 package robin
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"go.trulyao.dev/robin/generator"
+	"go.trulyao.dev/robin/types"
 )
 
 type (
@@ -19,11 +23,18 @@ type (
 		// Internal pointer to the current robin instance
 		robin *Robin
 
+		// Guards the port and route, they are only the defaults for `Serve` and are never changed by the options it is called with
+		mu sync.RWMutex
+
 		// Port to run the server on
 		port int
 
 		// Route to run the robin handler on
 		route string
+
+		// The handler used when the instance is served as an `http.Handler`, built lazily or with `ConfigureHandler`
+		handlerMu sync.RWMutex
+		handler   http.Handler
 	}
 
 	CorsOptions struct {
@@ -58,6 +69,9 @@ type (
 
 		// Whether to attach a 404 handler to the RESTful endpoints (enabled by default)
 		DisableNotFoundHandler bool
+
+		// How error responses from the RESTful endpoints are rendered, the format set in `Options` is used if empty
+		ErrorFormat ErrorFormat
 	}
 
 	ServeOptions struct {
@@ -73,6 +87,56 @@ type (
 		// REST options
 		// NOTE: Json API endpoints carry an RPC-style notation by default, if you need to customise this, use the `Alias()` method on the prodecure
 		RestApiOptions *RestApiOptions
+
+		// WebSocket options
+		// NOTE: the WebSocket endpoint is disabled by default
+		WebSocketOptions *WebSocketOptions
+
+		// How long to wait for in-flight calls to finish when shutting down, the remaining connections are closed after this (default is 10 seconds)
+		ShutdownTimeout time.Duration
+
+		// The address to bind to (e.g. `127.0.0.1`), the server listens on all interfaces if empty
+		Host string
+
+		// Maximum duration for reading the entire request, including the body, zero means no timeout
+		ReadTimeout time.Duration
+
+		// Maximum duration for reading the request headers, `ReadTimeout` is used if zero
+		ReadHeaderTimeout time.Duration
+
+		// Maximum duration before timing out writes of the response, zero means no timeout
+		//
+		// WARNING: this also applies to streamed responses (e.g. subscriptions), they are cut off once it elapses
+		WriteTimeout time.Duration
+
+		// Maximum amount of time to wait for the next request on a keep-alive connection, `ReadTimeout` is used if zero
+		IdleTimeout time.Duration
+
+		// Maximum size of the request headers in bytes (default is 1MB)
+		MaxHeaderBytes int
+
+		// Serve over TLS, the server is served over plain HTTP if this is nil
+		TLS *TLSOptions
+
+		// Serve on the listeners passed by systemd-style socket activation (`LISTEN_FDS`) instead of the host and port, the host and port are used if there are none
+		SocketActivation bool
+	}
+
+	TLSOptions struct {
+		// Paths to the PEM-encoded certificate and private key
+		CertFile string
+		KeyFile  string
+
+		// Base TLS configuration, this is cloned and the certificate files (if any) are added to it
+		//
+		// NOTE: either this (with certificates or `GetCertificate` set) or `CertFile` and `KeyFile` must be provided
+		Config *tls.Config
+
+		// Path to the PEM-encoded CA certificates used to verify client certificates, this enables mutual TLS; the verified certificate is available via `ctx.ClientCertificate()`
+		ClientCAFile string
+
+		// How client certificates are requested and verified when `ClientCAFile` is set (default is `tls.RequireAndVerifyClientCert`)
+		ClientAuth tls.ClientAuthType
 	}
 )
 
@@ -87,9 +151,9 @@ func PreflightHandler(w http.ResponseWriter, opts *CorsOptions) {
 
 	w.Header().Set("Access-Control-Allow-Origin", strings.Join(opts.Origins, ","))
 	w.Header().
-		Set("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Origin,Accept, X-Requested-With, Content-Type, Access-Control-Request-Method, Access-Control-Request-Headers")
+		Set("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Origin,Accept, X-Requested-With, Content-Type, Access-Control-Request-Method, Access-Control-Request-Headers, "+TimeoutHeader)
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 }
 
 func CorsHandler(w http.ResponseWriter, opts *CorsOptions) {
@@ -107,89 +171,176 @@ func CorsHandler(w http.ResponseWriter, opts *CorsOptions) {
 // Robin returns the internal robin instance which allowes for more control over the instance if ever needed
 func (i *Instance) Robin() *Robin { return i.robin }
 
-// Serve starts the robin server on the specified port
-func (i *Instance) Serve(opts ...ServeOptions) error {
-	corsOpts := &CorsOptions{
-		Origins: []string{"*"},
-		Headers: []string{"Content-Type", "Authorization"},
-		Methods: []string{"POST", "OPTIONS"},
+// resolveAddress returns the port and route to serve on, the ones set on the instance are used if they are not set in the options
+func (i *Instance) resolveAddress(config *ServeOptions) (int, string, error) {
+	i.mu.RLock()
+	port, route := i.port, i.route
+	i.mu.RUnlock()
+
+	if config == nil {
+		return port, trimUrlPath(route), nil
 	}
-	var (
-		restApiOpts *RestApiOptions
-		config   *ServeOptions
-	)
 
-	if len(opts) > 0 {
-		config = &opts[0]
+	if config.Port > 65535 {
+		return 0, "", errors.New("invalid port provided")
+	}
 
-		optsPort := config.Port
-		if optsPort > 65535 {
-			return errors.New("invalid port provided")
+	if config.Port > 0 {
+		if config.Port < 1024 {
+			slog.Warn("⚠️ Running robin on a privileged port", slog.Int("port", config.Port))
 		}
 
-		if optsPort > 0 {
-			if optsPort < 1024 {
-				slog.Warn("⚠️ Running robin on a privileged port", slog.Int("port", optsPort))
-			}
+		port = config.Port
+	}
 
-			i.port = optsPort
-		}
+	route = trimUrlPath(config.Route)
 
-		i.route = trimUrlPath(config.Route)
-		// WARNING: If the REST API is enabled, we cannot attach the route to `/` since we need that for the 404 endpoint
-		if i.route == "" && opts[0].RestApiOptions != nil && opts[0].RestApiOptions.Enable {
-			slog.Warn("⚠ Robin cannot be attached to the root path at `/` when RESTful endpoints are enabled, using `/_robin` instead. You can customise this by setting the `Route` option in the `ServeOptions` struct.")
+	// WARNING: If the REST API is enabled, we cannot attach the route to `/` since we need that for the 404 endpoint
+	if route == "" && config.RestApiOptions != nil && config.RestApiOptions.Enable {
+		slog.Warn("⚠ Robin cannot be attached to the root path at `/` when RESTful endpoints are enabled, using `/_robin` instead. You can customise this by setting the `Route` option in the `ServeOptions` struct.")
 
-			i.route = "_robin"
-		}
+		route = "_robin"
+	}
 
-		if config.CorsOptions != nil {
-			corsOpts = config.CorsOptions
-		}
+	return port, route, nil
+}
 
-		if config.RestApiOptions != nil {
-			restApiOpts = config.RestApiOptions
-		}
+// buildHandler builds the handler that serves the robin handler (on the given route), the RESTful endpoints and the WebSocket endpoint with CORS applied to all of them
+func (i *Instance) buildHandler(config *ServeOptions, route string) http.Handler {
+	corsOpts := &CorsOptions{
+		Origins: []string{"*"},
+		Headers: []string{"Content-Type", "Authorization", TimeoutHeader},
+		Methods: []string{"GET", "POST", "OPTIONS"},
+	}
+
+	if config == nil {
+		config = &ServeOptions{}
+	}
+
+	if config.CorsOptions != nil {
+		corsOpts = config.CorsOptions
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /"+i.route, func(w http.ResponseWriter, r *http.Request) {
-		CorsHandler(w, corsOpts)
-		i.Handler()(w, r)
-	})
+	mux.HandleFunc("POST /"+route, i.Handler())
+
+	// Queries can also be called via GET requests so that they can be cached by browsers and CDNs
+	mux.HandleFunc("GET /"+route, i.Handler())
 
-	// Handle CORS preflight requests
-	mux.HandleFunc("/"+i.route, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "OPTIONS" {
+	i.AttachRestEndpoints(mux, config.RestApiOptions)
+
+	if webSocketOpts := config.WebSocketOptions; webSocketOpts != nil && webSocketOpts.Enable {
+		wsRoute := trimUrlPath(webSocketOpts.Route)
+		if wsRoute == "" {
+			wsRoute = strings.TrimPrefix(route+"/ws", "/")
+		}
+
+		mux.HandleFunc("GET /"+wsRoute, i.WebSocketHandler(*webSocketOpts))
+		slog.Info("🔌 WebSocket endpoint is enabled", slog.String("route", "/"+wsRoute))
+	}
+
+	// CORS is handled in front of the router so that the RESTful endpoints get the same headers (and preflight responses) as the robin handler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
 			PreflightHandler(w, corsOpts)
 			return
 		}
-	})
-
-	i.AttachRestEndpoints(mux, restApiOpts)
 
-	slog.Info(
-		"📡 Robin server is listening",
-		slog.Int("port", i.port),
-		slog.String("route", "/"+i.route),
-	)
-	return http.ListenAndServe(fmt.Sprintf(":%d", i.port), mux)
+		CorsHandler(w, corsOpts)
+		mux.ServeHTTP(w, r)
+	})
 }
 
 // Handler returns the robin handler to be used with a custom (mux) router
+//
+// NOTE: this only serves procedure calls, use the instance itself as an `http.Handler` (see `ServeHTTP`) to also get the RESTful endpoints, the WebSocket endpoint and CORS handling
 func (i *Instance) Handler() http.HandlerFunc {
 	return i.robin.serveHTTP
 }
 
+// ConfigureHandler sets the options the instance serves requests with when it is used as an `http.Handler` (see `ServeHTTP`), the defaults are used if this is never called
+//
+// NOTE: `Port`, `Host`, the timeouts and TLS options only apply to `Serve` and are ignored here
+func (i *Instance) ConfigureHandler(opts ServeOptions) error {
+	_, route, err := i.resolveAddress(&opts)
+	if err != nil {
+		return err
+	}
+
+	handler := i.buildHandler(&opts, route)
+
+	i.handlerMu.Lock()
+	defer i.handlerMu.Unlock()
+
+	i.handler = handler
+	return nil
+}
+
+// ServeHTTP serves everything `Serve` does (procedure calls, RESTful endpoints, the WebSocket endpoint and CORS) so that the instance can be mounted in an existing application, including under a prefix with `http.StripPrefix`
+//
+// For example: `mux.Handle("/rpc/", http.StripPrefix("/rpc", instance))`
+func (i *Instance) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handler, err := i.httpHandler()
+	if err != nil {
+		i.robin.sendError(w, types.NewContext(req, &w), err)
+		return
+	}
+
+	// Stripping a prefix with a trailing slash (e.g. `/rpc/`) leaves the path without a leading slash which the router would not match
+	if !strings.HasPrefix(req.URL.Path, "/") {
+		u := *req.URL
+		u.Path = "/" + u.Path
+		if u.RawPath != "" {
+			u.RawPath = "/" + u.RawPath
+		}
+
+		req = req.WithContext(req.Context())
+		req.URL = &u
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+// httpHandler returns the handler the instance serves requests with as an `http.Handler`, it is built with the default options on first use if `ConfigureHandler` was never called
+func (i *Instance) httpHandler() (http.Handler, error) {
+	i.handlerMu.RLock()
+	handler := i.handler
+	i.handlerMu.RUnlock()
+
+	if handler != nil {
+		return handler, nil
+	}
+
+	i.handlerMu.Lock()
+	defer i.handlerMu.Unlock()
+
+	if i.handler == nil {
+		_, route, err := i.resolveAddress(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		i.handler = i.buildHandler(nil, route)
+	}
+
+	return i.handler, nil
+}
+
 // SetPort sets the port to run the server on (default is 8081; to avoid conflicts with other services)
 // WARNING: this only applies when calling `Serve()`, if you're using the default handler, you can set the port directly on the `http.Server` instance, you may have to update the client side to reflect the new port
 func (i *Instance) SetPort(port int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
 	i.port = port
 }
 
 // SetRoute sets the route to run the robin handler on (default is `/_robin`)
 // WARNING: this only applies when calling `Serve()`, if you're using the default handler, you can set the route using a mux router or similar, ensure that the client side reflects the new route
 func (i *Instance) SetRoute(route string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
 	i.route = route
 }
 
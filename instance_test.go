@@ -0,0 +1,139 @@
+package robin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_InstanceAsHandler(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("ping", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "pong", nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	err = instance.ConfigureHandler(robin.ServeOptions{
+		Route:          "_robin",
+		RestApiOptions: &robin.RestApiOptions{Enable: true},
+		CorsOptions:    &robin.CorsOptions{Origins: []string{"https://app.test"}, Methods: []string{"GET", "POST"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to configure handler: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	mux.Handle("/rpc/", http.StripPrefix("/rpc", instance))
+	mux.Handle("/v2/", http.StripPrefix("/v2/", instance))
+
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		code        int
+		body        string
+	}{
+		{"rpc call", http.MethodPost, "/rpc/_robin?" + robin.ProcNameKey + "=q__ping", 200, `{"data":"pong","ok":true}`},
+		{"rest call", http.MethodGet, "/rpc/api/ping", 200, `pong`},
+		{"prefix with trailing slash", http.MethodGet, "/v2/api/ping", 200, `pong`},
+		{"missing rest endpoint", http.MethodGet, "/rpc/api/missing", 404, `Resource not found`},
+		{"application route", http.MethodGet, "/health", 200, `ok`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(test.method, test.target, strings.NewReader("")))
+
+			if rec.Code != test.code {
+				t.Errorf("expected status code %d, got %d", test.code, rec.Code)
+			}
+
+			if !strings.Contains(rec.Body.String(), test.body) {
+				t.Errorf("expected body to contain %s, got %s", test.body, rec.Body.String())
+			}
+
+			if strings.HasPrefix(test.target, "/rpc") && rec.Header().Get("Access-Control-Allow-Origin") != "https://app.test" {
+				t.Errorf("expected CORS headers, got %v", rec.Header())
+			}
+		})
+	}
+
+	// Preflight requests are answered for the RESTful endpoints too
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/rpc/api/ping", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("expected a preflight response, got %d with %v", rec.Code, rec.Header())
+	}
+}
+
+func Test_InstanceHandlerConcurrency(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("ping", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "pong", nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for n := range 8 {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			instance.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_robin?"+robin.ProcNameKey+"=q__ping", strings.NewReader("")))
+		}()
+
+		go func() {
+			defer wg.Done()
+			if err := instance.ConfigureHandler(robin.ServeOptions{Route: "_robin"}); err != nil {
+				t.Errorf("failed to configure handler: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if _, err := instance.Server(robin.ServeOptions{Port: 9000 + n, Route: "rpc"}); err != nil {
+				t.Errorf("failed to create server: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The options a server was created with only apply to that server
+	server, err := instance.Server()
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if server.Addr != ":8081" {
+		t.Errorf("expected the default address to be kept, got %s", server.Addr)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_robin?"+robin.ProcNameKey+"=q__ping", strings.NewReader("")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the default route to be kept, got status %d", rec.Code)
+	}
+}
@@ -0,0 +1,263 @@
+package guarded
+
+import (
+	"encoding"
+	"encoding/json"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.trulyao.dev/robin/types"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// CheckPayload walks a generically decoded payload (as produced by decoding into `any`) alongside the type it was meant to be decoded into and reports every value that does not match
+//
+// NOTE: this follows the rules of `encoding/json`, so `null` is accepted everywhere and unknown object keys are ignored
+func CheckPayload(t reflect.Type, value any) []types.FieldError {
+	var fields []types.FieldError
+	checkValue(t, value, "", &fields)
+	return fields
+}
+
+func checkValue(t reflect.Type, value any, path string, fields *[]types.FieldError) {
+	if value == nil || t == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	mismatch := func(expected string) {
+		*fields = append(*fields, types.FieldError{Path: path, Expected: expected, Received: JSONTypeOf(value)})
+	}
+
+	// time.Time is common enough to be worth a more useful error than whatever it fails to parse with
+	if t == timeType {
+		if s, ok := value.(string); !ok {
+			mismatch("date-time")
+		} else if _, err := time.Parse(time.RFC3339, s); err != nil {
+			mismatch("date-time")
+		}
+		return
+	}
+
+	// Types that decode themselves can accept anything as far as we know, except text unmarshalers which always expect a string
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return
+	}
+
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		if _, ok := value.(string); !ok {
+			mismatch("string")
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			mismatch("boolean")
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !isInteger(value, t) {
+			mismatch("integer")
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if _, ok := toFloat(value); !ok {
+			mismatch("number")
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			mismatch("string")
+		}
+
+	case reflect.Slice, reflect.Array:
+		// Byte slices are encoded as base64 strings
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := value.(string); !ok {
+				mismatch("string")
+			}
+			return
+		}
+
+		items, ok := value.([]any)
+		if !ok {
+			mismatch("array")
+			return
+		}
+
+		for i, item := range items {
+			checkValue(t.Elem(), item, path+"/"+strconv.Itoa(i), fields)
+		}
+
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			mismatch("object")
+			return
+		}
+
+		// Keys are walked in order so that the reported fields are always in the same order
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			checkValue(t.Elem(), object[key], path+"/"+escapePointerToken(key), fields)
+		}
+
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			mismatch("object")
+			return
+		}
+
+		structFields := jsonFields(t)
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			item := object[key]
+			field, ok := findJSONField(structFields, key)
+			if !ok {
+				continue
+			}
+
+			// Values quoted with the `string` option are always sent as strings
+			if field.quoted {
+				if _, ok := item.(string); ok {
+					continue
+				}
+			}
+
+			checkValue(field.typ, item, path+"/"+escapePointerToken(key), fields)
+		}
+	}
+}
+
+// isInteger reports whether the value is a whole number that fits in the given integer type
+func isInteger(value any, t reflect.Type) bool {
+	f, ok := toFloat(value)
+	if !ok || f != math.Trunc(f) || math.IsInf(f, 0) {
+		return false
+	}
+
+	if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr {
+		return f >= 0 && f < math.Ldexp(1, t.Bits())
+	}
+
+	limit := math.Ldexp(1, t.Bits()-1)
+	return f >= -limit && f < limit
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// JSONTypeOf returns the JSON type of a generically decoded value
+func JSONTypeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+
+	return reflect.TypeOf(value).String()
+}
+
+// escapePointerToken escapes a key for use in a JSON pointer
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+type jsonField struct {
+	name   string
+	typ    reflect.Type
+	quoted bool
+}
+
+// jsonFields returns the fields of a struct the way `encoding/json` sees them, fields of embedded structs are promoted unless they are tagged
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+
+	for i := range t.NumField() {
+		sf := t.Field(i)
+
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" {
+			embedded := sf.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(embedded)...)
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, jsonField{name: name, typ: sf.Type, quoted: strings.Contains(","+opts+",", ",string,")})
+	}
+
+	return fields
+}
+
+// findJSONField finds the field a key decodes into, preferring an exact match like `encoding/json` does
+func findJSONField(fields []jsonField, key string) (jsonField, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field, true
+		}
+	}
+
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field, true
+		}
+	}
+
+	return jsonField{}, false
+}
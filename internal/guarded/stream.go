@@ -0,0 +1,117 @@
+package guarded
+
+import (
+	"reflect"
+
+	"go.trulyao.dev/robin/types"
+)
+
+type StreamKind int
+
+const (
+	// The value is not a stream
+	StreamNone StreamKind = iota
+
+	// A receive-only (or bidirectional) channel e.g. `<-chan T`
+	StreamChannel
+
+	// An iterator e.g. `iter.Seq[T]`
+	StreamSeq
+
+	// An iterator that can report errors mid-stream e.g. `iter.Seq2[T, error]`
+	StreamSeqWithError
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// DetectStream reports whether the given type is a channel or an iterator that can be streamed, and if so, the type of the values it produces
+func DetectStream(t reflect.Type) (StreamKind, reflect.Type) {
+	if t == nil {
+		return StreamNone, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Chan:
+		if t.ChanDir()&reflect.RecvDir == 0 {
+			return StreamNone, nil
+		}
+
+		return StreamChannel, t.Elem()
+
+	case reflect.Func:
+		// Iterators have the shape `func(yield func(...) bool)`
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			return StreamNone, nil
+		}
+
+		yield := t.In(0)
+		if yield.Kind() != reflect.Func || yield.NumOut() != 1 || yield.Out(0).Kind() != reflect.Bool {
+			return StreamNone, nil
+		}
+
+		switch yield.NumIn() {
+		case 1:
+			return StreamSeq, yield.In(0)
+		case 2:
+			if yield.In(1) == errorType {
+				return StreamSeqWithError, yield.In(0)
+			}
+		}
+	}
+
+	return StreamNone, nil
+}
+
+// ToStream converts a channel or an iterator into a stream that can be driven by a transport
+//
+// The done channel is used to stop waiting on channels when the caller goes away since there is no other way to interrupt a blocked receive
+func ToStream(kind StreamKind, value any, done <-chan struct{}) types.Stream {
+	v := reflect.ValueOf(value)
+
+	return func(emit func(any) error) error {
+		if !v.IsValid() || v.IsNil() {
+			return nil
+		}
+
+		switch kind {
+		case StreamChannel:
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: v},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+			}
+
+			for {
+				chosen, item, ok := reflect.Select(cases)
+				if chosen == 1 || !ok {
+					return nil
+				}
+
+				if err := emit(item.Interface()); err != nil {
+					return err
+				}
+			}
+
+		case StreamSeq, StreamSeqWithError:
+			var streamErr error
+
+			yield := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+				if kind == StreamSeqWithError && !args[1].IsNil() {
+					streamErr = args[1].Interface().(error)
+					return []reflect.Value{reflect.ValueOf(false)}
+				}
+
+				if err := emit(args[0].Interface()); err != nil {
+					streamErr = err
+					return []reflect.Value{reflect.ValueOf(false)}
+				}
+
+				return []reflect.Value{reflect.ValueOf(true)}
+			})
+
+			v.Call([]reflect.Value{yield})
+			return streamErr
+		}
+
+		return nil
+	}
+}
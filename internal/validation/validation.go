@@ -0,0 +1,486 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.trulyao.dev/robin/types"
+)
+
+// TagName is the struct tag the rules are read from e.g. `validate:"required,min=3"`
+const TagName = "validate"
+
+type Kind string
+
+const (
+	KindString  Kind = "string"
+	KindNumber  Kind = "number"
+	KindBoolean Kind = "boolean"
+	KindArray   Kind = "array"
+	KindObject  Kind = "object"
+	KindMap     Kind = "map"
+	KindAny     Kind = "any"
+)
+
+type (
+	Rule struct {
+		Name  string `json:"rule"`
+		Param string `json:"param,omitempty"`
+	}
+
+	// Schema describes the rules for a value and everything nested in it, it is exported as is to the generated client so that it can validate payloads before sending them
+	Schema struct {
+		Kind  Kind   `json:"kind"`
+		Rules []Rule `json:"rules,omitempty"`
+
+		// The schemas of the fields of an object, keyed by their JSON names
+		Fields map[string]*Schema `json:"fields,omitempty"`
+
+		// The schema of every item in an array or value in a map
+		Items *Schema `json:"items,omitempty"`
+
+		// The fields of a struct in declaration order, this is what the Go side walks
+		structFields []structField
+	}
+
+	structField struct {
+		name   string
+		index  []int
+		schema *Schema
+	}
+
+	cacheEntry struct {
+		schema *Schema
+		err    error
+	}
+)
+
+var (
+	cache sync.Map // map[reflect.Type]cacheEntry
+
+	emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidRegex  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// For returns the validation schema for the given type, nil is returned if neither the type nor anything nested in it has any rules
+//
+// NOTE: schemas are cached per type, so this is cheap to call on every request
+func For(t reflect.Type) (*Schema, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	if entry, ok := cache.Load(t); ok {
+		return entry.(cacheEntry).schema, entry.(cacheEntry).err
+	}
+
+	schema, err := build(t, nil, map[reflect.Type]bool{})
+	cache.Store(t, cacheEntry{schema: schema, err: err})
+
+	return schema, err
+}
+
+// Validate checks the value against the rules declared on its type, a `ValidationError` listing every failed rule is returned if it doesn't pass
+func Validate(value any) error {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return nil
+	}
+
+	schema, err := For(v.Type())
+	if err != nil || schema == nil {
+		return err
+	}
+
+	var fields []types.ValidationFieldError
+	validateValue(schema, v, "", &fields)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return types.ValidationError{Fields: fields}
+}
+
+// build creates the schema for the type with the given rules, types that are currently being built are skipped to avoid infinite recursion on recursive types
+func build(t reflect.Type, rules []Rule, building map[reflect.Type]bool) (*Schema, error) {
+	t = indirect(t)
+
+	schema := &Schema{Kind: kindOf(t), Rules: rules}
+
+	for _, rule := range rules {
+		if err := checkRule(rule, schema.Kind); err != nil {
+			return nil, err
+		}
+	}
+
+	if building[t] {
+		return prune(schema), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+
+		building[t] = true
+		items, err := build(t.Elem(), nil, building)
+		delete(building, t)
+		if err != nil {
+			return nil, err
+		}
+		schema.Items = items
+
+	case reflect.Struct:
+		if t == timeType {
+			break
+		}
+
+		building[t] = true
+		defer delete(building, t)
+
+		for _, sf := range reflect.VisibleFields(t) {
+			if !sf.IsExported() || (sf.Anonymous && sf.Tag.Get("json") == "") {
+				continue
+			}
+
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+
+			if name == "" {
+				name = sf.Name
+			}
+
+			fieldRules, err := parseTag(sf.Tag.Get(TagName))
+			if err != nil {
+				return nil, fmt.Errorf("invalid validation rules on field `%s` of `%s`: %w", sf.Name, t, err)
+			}
+
+			fieldSchema, err := build(sf.Type, fieldRules, building)
+			if err != nil {
+				return nil, err
+			}
+
+			// Maps are kept even without rules so that the client can tell them apart from structs, an empty map is not a zero value but a struct with only zero values is
+			if fieldSchema == nil && kindOf(indirect(sf.Type)) == KindMap {
+				fieldSchema = &Schema{Kind: KindMap}
+			}
+
+			if fieldSchema == nil {
+				continue
+			}
+
+			if schema.Fields == nil {
+				schema.Fields = make(map[string]*Schema)
+			}
+
+			schema.Fields[name] = fieldSchema
+			schema.structFields = append(schema.structFields, structField{name: name, index: sf.Index, schema: fieldSchema})
+		}
+	}
+
+	return prune(schema), nil
+}
+
+// prune drops schemas without any rules so that only what needs to be checked is walked
+func prune(schema *Schema) *Schema {
+	if len(schema.Rules) > 0 || schema.Items != nil {
+		return schema
+	}
+
+	for _, field := range schema.Fields {
+		if !isBareMap(field) {
+			return schema
+		}
+	}
+
+	return nil
+}
+
+// isBareMap returns whether the schema only marks a struct field as a map, see `build`
+func isBareMap(schema *Schema) bool {
+	return schema.Kind == KindMap && len(schema.Rules) == 0 && schema.Items == nil
+}
+
+// indirect returns the type pointers point to
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+func kindOf(t reflect.Type) Kind {
+	switch t.Kind() {
+	case reflect.String:
+		return KindString
+	case reflect.Bool:
+		return KindBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return KindNumber
+	case reflect.Slice, reflect.Array:
+		// Byte slices are base64 strings and times are formatted strings in JSON, so only presence can be checked consistently on both ends
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return KindAny
+		}
+		return KindArray
+	case reflect.Map:
+		return KindMap
+	case reflect.Struct:
+		if t == timeType {
+			return KindAny
+		}
+		return KindObject
+	}
+
+	return KindAny
+}
+
+// parseTag parses a tag like `required,min=3,oneof=a b`
+func parseTag(tag string) ([]Rule, error) {
+	if strings.TrimSpace(tag) == "" {
+		return nil, nil
+	}
+
+	var rules []Rule
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			return nil, fmt.Errorf("empty rule in `%s`", tag)
+		}
+
+		rules = append(rules, Rule{Name: name, Param: param})
+	}
+
+	return rules, nil
+}
+
+// checkRule makes sure the rule exists, has a valid parameter and makes sense for the kind of value it is applied to
+func checkRule(rule Rule, kind Kind) error {
+	switch rule.Name {
+	case "required", "omitempty":
+		if rule.Param != "" {
+			return fmt.Errorf("rule `%s` does not take a parameter", rule.Name)
+		}
+
+	case "min", "max", "len":
+		if _, err := strconv.ParseFloat(rule.Param, 64); err != nil {
+			return fmt.Errorf("rule `%s` expects a number, got `%s`", rule.Name, rule.Param)
+		}
+
+		if kind == KindBoolean || kind == KindAny || kind == KindObject || (rule.Name == "len" && kind == KindNumber) {
+			return fmt.Errorf("rule `%s` cannot be applied to a value of kind `%s`", rule.Name, kind)
+		}
+
+	case "email", "url", "uuid":
+		if kind != KindString {
+			return fmt.Errorf("rule `%s` can only be applied to strings", rule.Name)
+		}
+
+	case "oneof":
+		if len(strings.Fields(rule.Param)) == 0 {
+			return fmt.Errorf("rule `oneof` expects a space-separated list of values")
+		}
+
+		if kind != KindString && kind != KindNumber {
+			return fmt.Errorf("rule `oneof` can only be applied to strings and numbers")
+		}
+
+	default:
+		return fmt.Errorf("unknown rule `%s`", rule.Name)
+	}
+
+	return nil
+}
+
+func validateValue(schema *Schema, v reflect.Value, path string, fields *[]types.ValidationFieldError) {
+	isNil := false
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			isNil = true
+			break
+		}
+		v = v.Elem()
+	}
+
+	empty := isNil || v.IsZero()
+
+	for _, rule := range schema.Rules {
+		if rule.Name == "omitempty" && empty {
+			return
+		}
+	}
+
+	for _, rule := range schema.Rules {
+		if rule.Name == "required" {
+			if empty {
+				*fields = append(*fields, types.ValidationFieldError{Path: path, Rule: rule.Name, Message: "is required"})
+				return
+			}
+			continue
+		}
+
+		// Nothing else can be checked on a missing value
+		if isNil {
+			return
+		}
+
+		if message, ok := checkValue(rule, schema.Kind, v); !ok {
+			*fields = append(*fields, types.ValidationFieldError{Path: path, Rule: rule.Name, Param: rule.Param, Message: message})
+		}
+	}
+
+	if isNil {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if schema.Items == nil {
+			return
+		}
+
+		for i := range v.Len() {
+			validateValue(schema.Items, v.Index(i), path+"/"+strconv.Itoa(i), fields)
+		}
+
+	case reflect.Map:
+		if schema.Items == nil {
+			return
+		}
+
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(schema.Items, iter.Value(), path+"/"+escapePointerToken(fmt.Sprint(iter.Key().Interface())), fields)
+		}
+
+	case reflect.Struct:
+		for _, field := range schema.structFields {
+			fv, err := v.FieldByIndexErr(field.index)
+			if err != nil {
+				// The field is promoted from a nil embedded pointer, so it doesn't exist
+				continue
+			}
+
+			validateValue(field.schema, fv, path+"/"+escapePointerToken(field.name), fields)
+		}
+	}
+}
+
+// checkValue checks a single rule against a value that is known to be present, the message describes what was expected if it fails
+func checkValue(rule Rule, kind Kind, v reflect.Value) (string, bool) {
+	switch rule.Name {
+	case "min", "max", "len":
+		limit, _ := strconv.ParseFloat(rule.Param, 64)
+
+		var size float64
+		switch kind {
+		case KindString:
+			size = float64(utf8.RuneCountInString(v.String()))
+		case KindNumber:
+			size = numberOf(v)
+		default:
+			size = float64(v.Len())
+		}
+
+		var ok bool
+		var bound string
+		switch rule.Name {
+		case "min":
+			ok, bound = size >= limit, "at least"
+		case "max":
+			ok, bound = size <= limit, "at most"
+		default:
+			ok, bound = size == limit, "exactly"
+		}
+
+		switch kind {
+		case KindString:
+			return fmt.Sprintf("must be %s %s characters long", bound, rule.Param), ok
+		case KindNumber:
+			return fmt.Sprintf("must be %s %s", bound, rule.Param), ok
+		default:
+			return fmt.Sprintf("must contain %s %s items", bound, rule.Param), ok
+		}
+
+	case "email":
+		s := v.String()
+		address, err := mail.ParseAddress(s)
+		return "must be a valid email address", err == nil && address.Address == s && emailRegex.MatchString(s)
+
+	case "url":
+		u, err := url.ParseRequestURI(v.String())
+		return "must be a valid URL", err == nil && u.Scheme != "" && u.Host != ""
+
+	case "uuid":
+		return "must be a valid UUID", uuidRegex.MatchString(v.String())
+
+	case "oneof":
+		options := strings.Fields(rule.Param)
+		message := "must be one of: " + strings.Join(options, ", ")
+
+		var value string
+		if kind == KindNumber {
+			value = strconv.FormatFloat(numberOf(v), 'f', -1, 64)
+		} else {
+			value = v.String()
+		}
+
+		for _, option := range options {
+			if option == value {
+				return message, true
+			}
+
+			// Numbers can be written in different ways e.g. `1.0` and `1`
+			if kind == KindNumber {
+				if n, err := strconv.ParseFloat(option, 64); err == nil && strconv.FormatFloat(n, 'f', -1, 64) == value {
+					return message, true
+				}
+			}
+		}
+
+		return message, false
+	}
+
+	return "", true
+}
+
+func numberOf(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	case v.CanFloat():
+		return v.Float()
+	}
+
+	return 0
+}
+
+// escapePointerToken escapes a key for use in a JSON pointer
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,425 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC 6455), just enough for robin to multiplex procedure calls over a single connection
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The GUID appended to the client's key to compute the accept key as defined in the RFC
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type MessageType int
+
+const (
+	continuationFrame MessageType = 0x0
+
+	TextMessage   MessageType = 0x1
+	BinaryMessage MessageType = 0x2
+
+	closeFrame MessageType = 0x8
+	pingFrame  MessageType = 0x9
+	pongFrame  MessageType = 0xA
+)
+
+// Close codes as defined in the RFC
+const (
+	CloseNormalClosure     = 1000
+	CloseGoingAway         = 1001
+	CloseProtocolError     = 1002
+	CloseUnsupportedData   = 1003
+	CloseInvalidPayload    = 1007
+	CloseMessageTooBig     = 1009
+	CloseInternalServerErr = 1011
+)
+
+// Default maximum size of a single (reassembled) message in bytes
+const DefaultMaxMessageSize int64 = 1 << 20
+
+// Default time allowed for writing a single frame, a peer that stops reading would otherwise block writers forever
+const DefaultWriteTimeout = 10 * time.Second
+
+// How long `Close` waits for a pending write to finish before giving up on the close frame and closing the connection anyway
+const closeTimeout = time.Second
+
+var (
+	ErrClosed          = errors.New("websocket: connection closed")
+	ErrMessageTooBig   = errors.New("websocket: message too big")
+	ErrProtocol        = errors.New("websocket: protocol error")
+	ErrNotUpgrade      = errors.New("websocket: not a websocket upgrade request")
+	ErrUnsupportedType = errors.New("websocket: unsupported message type")
+	ErrIdleTimeout     = errors.New("websocket: idle timeout")
+	ErrWriteTimeout    = errors.New("websocket: write timeout")
+)
+
+type Conn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// Maximum size of a single message in bytes
+	maxMessageSize int64
+
+	// How long to wait for the next frame (of any kind, including pongs) before giving up on the peer, zero means forever
+	idleTimeout time.Duration
+
+	// How long writing a single frame may take before the connection is closed
+	writeTimeout time.Duration
+
+	// Only one frame can be written at a time, this is a channel rather than a mutex so that `Close` can stop waiting for it
+	writeLock chan struct{}
+	closeOnce sync.Once
+}
+
+// IsUpgradeRequest reports whether the request is asking to be upgraded to a websocket connection
+func IsUpgradeRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		headerContainsToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// Upgrade upgrades the HTTP connection to a websocket connection, nothing must have been written to the response before calling this
+func Upgrade(w http.ResponseWriter, r *http.Request, maxMessageSize int64) (*Conn, error) {
+	if !IsUpgradeRequest(r) {
+		return nil, ErrNotUpgrade
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrNotUpgrade, r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if key == "" {
+		return nil, fmt.Errorf("%w: missing key", ErrNotUpgrade)
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: failed to hijack connection: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n\r\n"
+
+	if _, err := rw.WriteString(response); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return newConn(conn, rw, maxMessageSize), nil
+}
+
+func newConn(conn net.Conn, rw *bufio.ReadWriter, maxMessageSize int64) *Conn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+
+	return &Conn{
+		conn:           conn,
+		rw:             rw,
+		maxMessageSize: maxMessageSize,
+		writeTimeout:   DefaultWriteTimeout,
+		writeLock:      make(chan struct{}, 1),
+	}
+}
+
+// ReadMessage reads the next complete data message from the connection, control frames are handled transparently
+//
+// ErrClosed is returned once the peer closes the connection
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		message     []byte
+	)
+
+	for {
+		if c.idleTimeout > 0 {
+			if err := c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout)); err != nil {
+				return 0, nil, c.readError(err)
+			}
+		}
+
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case pingFrame:
+			if err := c.writeFrame(pongFrame, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+
+		case pongFrame:
+			continue
+
+		case closeFrame:
+			// Echo the close frame back as required by the protocol before closing the underlying connection
+			_ = c.closeWithPayload(payload)
+			return 0, nil, ErrClosed
+
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				_ = c.Close(CloseProtocolError, "unexpected data frame in fragmented message")
+				return 0, nil, ErrProtocol
+			}
+			messageType = opcode
+
+		case continuationFrame:
+			if messageType == 0 {
+				_ = c.Close(CloseProtocolError, "unexpected continuation frame")
+				return 0, nil, ErrProtocol
+			}
+
+		default:
+			_ = c.Close(CloseProtocolError, "unknown opcode")
+			return 0, nil, ErrProtocol
+		}
+
+		if int64(len(message)+len(payload)) > c.maxMessageSize {
+			_ = c.Close(CloseMessageTooBig, "message too big")
+			return 0, nil, ErrMessageTooBig
+		}
+
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+
+		if messageType == TextMessage && !utf8.Valid(message) {
+			_ = c.Close(CloseInvalidPayload, "invalid utf-8 in text message")
+			return 0, nil, ErrProtocol
+		}
+
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage writes a single data message to the connection, it is safe to call from multiple goroutines
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return ErrUnsupportedType
+	}
+
+	return c.writeFrame(messageType, data)
+}
+
+// Close sends a close frame with the given code and reason and closes the underlying connection
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	return c.closeWithPayload(payload)
+}
+
+// closeWithPayload sends the close frame if it can be done quickly and closes the underlying connection either way
+//
+// NOTE: a writer stuck on a peer that stopped reading holds the write lock until its deadline, closing the connection is what unblocks it
+func (c *Conn) closeWithPayload(payload []byte) error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		timer := time.NewTimer(closeTimeout)
+		defer timer.Stop()
+
+		select {
+		case c.writeLock <- struct{}{}:
+			_ = c.writeFrameLocked(closeFrame, payload, closeTimeout)
+			<-c.writeLock
+		case <-timer.C:
+		}
+
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+// SetIdleTimeout sets how long `ReadMessage` waits for the next frame before failing with ErrIdleTimeout, any frame (including a pong) resets it
+//
+// NOTE: this has to be called before the connection is read from
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	c.idleTimeout = timeout
+}
+
+// Ping sends a ping frame to the peer, the pong it replies with resets the idle timeout; it is safe to call from multiple goroutines
+func (c *Conn) Ping() error {
+	return c.writeFrame(pingFrame, nil)
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) readFrame() (fin bool, opcode MessageType, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.rw, header[:]); err != nil {
+		return false, 0, nil, c.readError(err)
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = MessageType(header[0] & 0x0F)
+
+	// Extensions are never negotiated, so the reserved bits must not be set
+	if header[0]&0x70 != 0 {
+		_ = c.Close(CloseProtocolError, "reserved bits set")
+		return false, 0, nil, ErrProtocol
+	}
+
+	// Frames sent by the client must always be masked
+	if header[1]&0x80 == 0 {
+		_ = c.Close(CloseProtocolError, "unmasked client frame")
+		return false, 0, nil, ErrProtocol
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err = io.ReadFull(c.rw, extended[:]); err != nil {
+			return false, 0, nil, c.readError(err)
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+
+	case 127:
+		var extended [8]byte
+		if _, err = io.ReadFull(c.rw, extended[:]); err != nil {
+			return false, 0, nil, c.readError(err)
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+
+	isControl := opcode&0x8 != 0
+	if isControl && (length > 125 || !fin) {
+		_ = c.Close(CloseProtocolError, "invalid control frame")
+		return false, 0, nil, ErrProtocol
+	}
+
+	if length < 0 || length > c.maxMessageSize {
+		_ = c.Close(CloseMessageTooBig, "message too big")
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, c.readError(err)
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, c.readError(err)
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) writeFrame(opcode MessageType, payload []byte) error {
+	c.writeLock <- struct{}{}
+	defer func() { <-c.writeLock }()
+
+	return c.writeFrameLocked(opcode, payload, c.writeTimeout)
+}
+
+// writeFrameLocked writes a single frame, the caller must hold the write lock
+func (c *Conn) writeFrameLocked(opcode MessageType, payload []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return c.writeError(err)
+		}
+	}
+
+	// Frames sent by the server are never masked or fragmented
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|byte(opcode))
+
+	switch length := len(payload); {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return c.writeError(err)
+	}
+
+	if _, err := c.rw.Write(payload); err != nil {
+		return c.writeError(err)
+	}
+
+	return c.writeError(c.rw.Flush())
+}
+
+func (c *Conn) readError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return ErrClosed
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		_ = c.Close(CloseGoingAway, "idle timeout")
+		return ErrIdleTimeout
+	}
+
+	return err
+}
+
+func (c *Conn) writeError(err error) error {
+	if err != nil && (errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)) {
+		return ErrClosed
+	}
+
+	// Part of the frame may already have been written, so nothing else can be sent on the connection
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		_ = c.conn.Close()
+		return ErrWriteTimeout
+	}
+
+	return err
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,232 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestConn returns a server connection and the client end of the pipe it is connected to
+func newTestConn(t *testing.T, maxMessageSize int64) (*Conn, net.Conn) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	return newConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), maxMessageSize), client
+}
+
+// clientFrame encodes a frame the way a client sends it, i.e. masked
+func clientFrame(fin bool, opcode MessageType, payload []byte, masked bool) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	if !masked {
+		return append(frame, payload...)
+	}
+
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+// readServerFrame reads a single (unmasked) frame sent by the server
+func readServerFrame(t *testing.T, r io.Reader) (MessageType, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("failed to read frame header: %v", err)
+	}
+
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		t.Fatalf("expected an unfragmented and unmasked frame, got header %x", header)
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			t.Fatalf("failed to read frame length: %v", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			t.Fatalf("failed to read frame length: %v", err)
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("failed to read frame payload: %v", err)
+	}
+
+	return MessageType(header[0] & 0x0F), payload
+}
+
+type readResult struct {
+	messageType MessageType
+	message     []byte
+	err         error
+}
+
+func readInBackground(conn *Conn) <-chan readResult {
+	result := make(chan readResult, 1)
+	go func() {
+		messageType, message, err := conn.ReadMessage()
+		result <- readResult{messageType, message, err}
+	}()
+
+	return result
+}
+
+func Test_ReadFragmentedMessage(t *testing.T) {
+	conn, client := newTestConn(t, 0)
+	result := readInBackground(conn)
+
+	if _, err := client.Write(clientFrame(false, TextMessage, []byte("Hel"), true)); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+
+	// Control frames can be interleaved with the fragments of a message
+	if _, err := client.Write(clientFrame(true, pingFrame, []byte("ping"), true)); err != nil {
+		t.Fatalf("failed to write ping: %v", err)
+	}
+
+	if opcode, payload := readServerFrame(t, client); opcode != pongFrame || string(payload) != "ping" {
+		t.Fatalf("expected a pong with the ping's payload, got opcode %d with %q", opcode, payload)
+	}
+
+	if _, err := client.Write(clientFrame(true, continuationFrame, []byte("lo"), true)); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+
+	got := <-result
+	if got.err != nil || got.messageType != TextMessage || string(got.message) != "Hello" {
+		t.Errorf("expected text message %q, got %d %q (%v)", "Hello", got.messageType, got.message, got.err)
+	}
+}
+
+func Test_ReadProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		code  uint16
+		err   error
+	}{
+		{"unmasked frame", clientFrame(true, TextMessage, []byte("hi"), false), CloseProtocolError, ErrProtocol},
+		{"reserved bits", append([]byte{0xC1}, clientFrame(true, TextMessage, []byte("hi"), true)[1:]...), CloseProtocolError, ErrProtocol},
+		{"fragmented control frame", clientFrame(false, pingFrame, nil, true), CloseProtocolError, ErrProtocol},
+		{"unexpected continuation", clientFrame(true, continuationFrame, []byte("hi"), true), CloseProtocolError, ErrProtocol},
+		{"invalid utf-8", clientFrame(true, TextMessage, []byte{0xff, 0xfe}, true), CloseInvalidPayload, ErrProtocol},
+		{"message too big", clientFrame(true, BinaryMessage, make([]byte, 200), true), CloseMessageTooBig, ErrMessageTooBig},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, client := newTestConn(t, 128)
+			result := readInBackground(conn)
+
+			// The server may stop reading halfway through the frame, so the rest of it can't be waited on
+			go func() { _, _ = client.Write(test.frame) }()
+
+			opcode, payload := readServerFrame(t, client)
+			if opcode != closeFrame || len(payload) < 2 {
+				t.Fatalf("expected a close frame, got opcode %d with %q", opcode, payload)
+			}
+
+			if code := binary.BigEndian.Uint16(payload); code != test.code {
+				t.Errorf("expected close code %d, got %d", test.code, code)
+			}
+
+			if got := <-result; !errors.Is(got.err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, got.err)
+			}
+		})
+	}
+}
+
+func Test_WriteTimeout(t *testing.T) {
+	conn, client := newTestConn(t, 0)
+	conn.writeTimeout = 50 * time.Millisecond
+
+	// The client never reads, so the write can't complete
+	start := time.Now()
+	if err := conn.WriteMessage(TextMessage, []byte("hello")); !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("expected %v, got %v", ErrWriteTimeout, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the write to give up after the write timeout, took %s", elapsed)
+	}
+
+	// Part of the frame may have been written, so the connection can't be used anymore
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(client); err != nil {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func Test_CloseWithBlockedWriter(t *testing.T) {
+	conn, _ := newTestConn(t, 0)
+	conn.writeTimeout = time.Hour
+
+	written := make(chan error, 1)
+	go func() { written <- conn.WriteMessage(TextMessage, []byte("hello")) }()
+
+	// Wait for the writer to be stuck on the client that never reads
+	for len(conn.writeLock) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close(CloseGoingAway, "shutting down") }()
+
+	select {
+	case <-closed:
+	case <-time.After(closeTimeout + time.Second):
+		t.Fatal("expected close not to wait for the blocked writer")
+	}
+
+	select {
+	case err := <-written:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("expected the blocked write to fail with %v, got %v", ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the blocked write to return once the connection is closed")
+	}
+}
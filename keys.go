@@ -0,0 +1,98 @@
+package robin
+
+import (
+	"fmt"
+)
+
+type (
+	// Key is a typed key for values scoped to a single call, it replaces string keys and type assertions on the state container
+	//
+	// Keys are compared by identity, not by name, so two keys created with the same name never collide
+	Key[T any] struct {
+		id *keyID
+	}
+
+	keyID struct {
+		name string
+	}
+)
+
+// NewKey creates a new typed key, the name is only used in error messages
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{id: &keyID{name: name}}
+}
+
+// Name returns the name the key was created with
+func (k Key[T]) Name() string {
+	if k.id == nil {
+		return ""
+	}
+
+	return k.id.name
+}
+
+// Set attaches the value to the context, it is visible to everything that runs after it for the current call
+func (k Key[T]) Set(ctx *Context, value T) {
+	ctx.WithValue(k.id, value)
+}
+
+// Get returns the value attached to the context and whether it was set
+func (k Key[T]) Get(ctx *Context) (T, bool) {
+	value, ok := ctx.Value(k.id).(T)
+	return value, ok
+}
+
+// MustGet returns the value attached to the context and panics if it was not set, use this for values that are guaranteed to be set by a middleware (see `Procedure.RequireKeys`)
+func (k Key[T]) MustGet(ctx *Context) T {
+	value, ok := k.Get(ctx)
+	if !ok {
+		panic(fmt.Sprintf("robin: no value set for key `%s`", k.Name()))
+	}
+
+	return value
+}
+
+// DeclareKeys declares the keys that a named global middleware (added with `Use` or `UseAround`) sets, so that `Build` can verify that every key a procedure requires is provided
+func (r *Robin) DeclareKeys(middlewareName string, keys ...ContextKey) *Robin {
+	if r.middlewareKeys == nil {
+		r.middlewareKeys = make(map[string][]ContextKey)
+	}
+
+	r.middlewareKeys[middlewareName] = append(r.middlewareKeys[middlewareName], keys...)
+	return r
+}
+
+// checkRequiredKeys makes sure every key the procedure requires is provided by the procedure itself or one of the global middleware that apply to it
+func (r *Robin) checkRequiredKeys(procedure Procedure, globalMiddlewareNames []string) error {
+	required := procedure.RequiredKeys()
+	if len(required) == 0 {
+		return nil
+	}
+
+	provided := make(map[ContextKey]bool)
+	for _, key := range procedure.ProvidedKeys() {
+		provided[key] = true
+	}
+
+	for _, name := range globalMiddlewareNames {
+		for _, key := range r.middlewareKeys[name] {
+			provided[key] = true
+		}
+	}
+
+	for _, key := range required {
+		if !provided[key] {
+			return RobinError{
+				Reason: fmt.Sprintf(
+					"Procedure `%s` requires the key `%s` but none of its middleware provide it, use `DeclareKeys` or `ProvideKeys` to declare the middleware that sets it",
+					procedure.Name(),
+					key.Name(),
+				),
+			}
+		}
+	}
+
+	return nil
+}
+
+var _ ContextKey = Key[any]{}
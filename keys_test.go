@@ -0,0 +1,110 @@
+package robin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+type keyUser struct {
+	ID   int
+	Name string
+}
+
+func Test_TypedKeys(t *testing.T) {
+	userKey := robin.NewKey[*keyUser]("user")
+	otherUserKey := robin.NewKey[*keyUser]("user")
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Use("auth", func(ctx *robin.Context) error {
+			userKey.Set(ctx, &keyUser{ID: 1, Name: "John"})
+			return nil
+		}).
+		DeclareKeys("auth", userKey).
+		Add(robin.Query("me", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			if _, ok := otherUserKey.Get(ctx); ok {
+				t.Errorf("expected keys with the same name not to collide")
+			}
+
+			return userKey.MustGet(ctx).Name, nil
+		}).RequireKeys(userKey)).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__me", strings.NewReader(""))
+	rec := httptest.NewRecorder()
+	instance.Handler()(rec, req)
+
+	if expected := `{"data":"John","ok":true}`; rec.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+	}
+}
+
+func Test_RequiredKeys(t *testing.T) {
+	userKey := robin.NewKey[*keyUser]("user")
+	noop := func(ctx *robin.Context) error { return nil }
+	handler := func(ctx *robin.Context, _ robin.Void) (string, error) { return "", nil }
+
+	tests := []struct {
+		description string
+		build       func(r *robin.Robin) *robin.Robin
+		fails       bool
+	}{
+		{
+			"key is not provided",
+			func(r *robin.Robin) *robin.Robin {
+				return r.Use("auth", noop).Add(robin.Query("me", handler).RequireKeys(userKey))
+			},
+			true,
+		},
+		{
+			"key is provided by a global middleware",
+			func(r *robin.Robin) *robin.Robin {
+				return r.Use("auth", noop).DeclareKeys("auth", userKey).Add(robin.Query("me", handler).RequireKeys(userKey))
+			},
+			false,
+		},
+		{
+			"providing middleware is excluded",
+			func(r *robin.Robin) *robin.Robin {
+				return r.Use("auth", noop).DeclareKeys("auth", userKey).Add(robin.Query("me", handler).RequireKeys(userKey).ExcludeMiddleware("auth"))
+			},
+			true,
+		},
+		{
+			"key is provided by the procedure's own middleware",
+			func(r *robin.Robin) *robin.Robin {
+				return r.Add(robin.Query("me", handler).WithMiddleware(noop).ProvideKeys(userKey).RequireKeys(userKey))
+			},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r, err := robin.New(robin.Options{})
+			if err != nil {
+				t.Fatalf("failed to create robin instance: %v", err)
+			}
+
+			_, err = test.build(r).Build()
+			if test.fails && (err == nil || !strings.Contains(err.Error(), "requires the key `user`")) {
+				t.Errorf("expected a missing key error, got %v", err)
+			}
+
+			if !test.fails && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
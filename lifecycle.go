@@ -0,0 +1,278 @@
+package robin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LifecycleHook is a function that is run when the server starts or shuts down (e.g. to open or close a database connection)
+type LifecycleHook func(ctx context.Context) error
+
+// stopSignal tells long-lived calls to stop when the server shuts down, it is re-armed when the instance is served again so that a shutdown does not stop the calls made to every server after it
+type stopSignal struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// DefaultShutdownTimeout is how long the server waits for in-flight calls to finish when shutting down if no timeout is set in `ServeOptions`
+const DefaultShutdownTimeout = 10 * time.Second
+
+// OnStart registers a hook that is run before the server starts serving requests, hooks are run in the order they are registered
+//
+// NOTE: the server is not started if any of them returns an error
+func (r *Robin) OnStart(hook LifecycleHook) *Robin {
+	r.startHooks = append(r.startHooks, hook)
+	return r
+}
+
+// OnShutdown registers a hook that is run after the server has stopped accepting requests and in-flight calls have finished, hooks are run in the reverse order they are registered so that resources are closed in the opposite order they were opened
+func (r *Robin) OnShutdown(hook LifecycleHook) *Robin {
+	r.shutdownHooks = append(r.shutdownHooks, hook)
+	return r
+}
+
+func (r *Robin) runStartHooks(ctx context.Context) error {
+	for _, hook := range r.startHooks {
+		if err := hook(ctx); err != nil {
+			return fmt.Errorf("start hook failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// runShutdownHooks runs every shutdown hook even if some of them fail, the errors are joined
+func (r *Robin) runShutdownHooks(ctx context.Context) error {
+	var errs []error
+
+	for i := len(r.shutdownHooks) - 1; i >= 0; i-- {
+		if err := r.shutdownHooks[i](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook failed: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func newStopSignal() *stopSignal {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &stopSignal{ctx: ctx, cancel: cancel}
+}
+
+// context returns the context that is cancelled when the signal is stopped
+func (s *stopSignal) context() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ctx
+}
+
+// arm replaces the signal if it has already been stopped and returns the function that stops it
+//
+// NOTE: the returned function only ever stops the signal that was current at the time, so a server that shuts down late can't stop calls made to the one that replaced it
+func (s *stopSignal) arm() context.CancelFunc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	return s.cancel
+}
+
+func (s *stopSignal) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cancel()
+}
+
+// stopOnShutdown makes the call's context get cancelled when the server starts shutting down, the returned function must be called once the call is done
+func (r *Robin) stopOnShutdown(ctx *Context) func() {
+	callCtx, cancel := context.WithCancel(ctx.Context())
+	ctx.SetContext(callCtx)
+
+	stop := context.AfterFunc(r.stopping.context(), cancel)
+
+	return func() {
+		stop()
+		cancel()
+	}
+}
+
+// Serve starts the robin server on the specified port, the server is shut down gracefully when the process receives an interrupt or a SIGTERM
+func (i *Instance) Serve(opts ...ServeOptions) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	return i.ServeContext(ctx, opts...)
+}
+
+// ServeContext starts the robin server on the specified port and shuts it down gracefully when the context is cancelled; the server stops accepting new requests, waits for in-flight calls to finish (up to `ServeOptions.ShutdownTimeout`) and runs the shutdown hooks
+//
+// NOTE: subscriptions and WebSocket connections are stopped as soon as the shutdown starts since they would otherwise hold it up until the timeout
+func (i *Instance) ServeContext(ctx context.Context, opts ...ServeOptions) error {
+	return i.serve(ctx, nil, opts...)
+}
+
+// ServeListener is the same as `Serve` but serves on the given listener (e.g. a Unix domain socket or an ephemeral port) instead of the configured host and port
+func (i *Instance) ServeListener(listener net.Listener, opts ...ServeOptions) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	return i.ServeListenerContext(ctx, listener, opts...)
+}
+
+// ServeListenerContext is the same as `ServeContext` but serves on the given listener instead of the configured host and port
+//
+// NOTE: the listener is closed when the server shuts down
+func (i *Instance) ServeListenerContext(ctx context.Context, listener net.Listener, opts ...ServeOptions) error {
+	if listener == nil {
+		return errors.New("no listener provided")
+	}
+
+	return i.serve(ctx, []net.Listener{listener}, opts...)
+}
+
+// serve serves on the given listeners until the context is cancelled, if there are none, the listeners passed by socket activation (if enabled) or a listener on the configured address are used
+func (i *Instance) serve(ctx context.Context, listeners []net.Listener, opts ...ServeOptions) error {
+	var config *ServeOptions
+	if len(opts) > 0 {
+		config = &opts[0]
+	}
+
+	server, route, err := i.server(config)
+	if err != nil {
+		closeListeners(listeners)
+		return err
+	}
+
+	if len(listeners) == 0 && config != nil && config.SocketActivation {
+		if listeners, err = ActivationListeners(); err != nil {
+			return err
+		}
+	}
+
+	if len(listeners) == 0 {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+
+		listeners = []net.Listener{listener}
+	}
+
+	if err := i.robin.runStartHooks(ctx); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+
+	// The server modifies its TLS config once it starts serving, so this has to be checked before then
+	useTLS := server.TLSConfig != nil
+
+	serveErr := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			// The certificates are already part of the TLS config
+			if useTLS {
+				serveErr <- server.ServeTLS(listener, "", "")
+				return
+			}
+
+			serveErr <- server.Serve(listener)
+		}()
+
+		slog.Info(
+			"📡 Robin server is listening",
+			slog.String("address", listener.Addr().String()),
+			slog.String("route", "/"+route),
+			slog.Bool("tls", useTLS),
+		)
+	}
+
+	select {
+	case err := <-serveErr:
+		// The server stopped serving on its own, the start hooks may have opened resources that need to be released
+		_ = server.Close()
+
+		hooksCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(config))
+		defer cancel()
+
+		return errors.Join(err, i.robin.runShutdownHooks(hooksCtx))
+
+	case <-ctx.Done():
+	}
+
+	return i.shutdown(server, shutdownTimeout(config))
+}
+
+// Start runs the start hooks, this is only needed when the instance is mounted in a server that is not started with `Serve` (or one of its variants)
+//
+// NOTE: long-lived calls are allowed again if they were stopped by a previous shutdown
+func (i *Instance) Start(ctx context.Context) error {
+	i.robin.stopping.arm()
+	return i.robin.runStartHooks(ctx)
+}
+
+// StopStreaming stops subscriptions, streamed responses and WebSocket connections, register it with `RegisterOnShutdown` on a server the instance is mounted in so that they don't hold up its shutdown
+func (i *Instance) StopStreaming() {
+	i.robin.stopping.stop()
+}
+
+// Shutdown stops long-lived calls (see `StopStreaming`) and runs the shutdown hooks, this is only needed when the instance is mounted in a server that is not started with `Serve` (or one of its variants) and should be called once that server's own `Shutdown` has returned
+func (i *Instance) Shutdown(ctx context.Context) error {
+	i.robin.stopping.stop()
+	return i.robin.runShutdownHooks(ctx)
+}
+
+// shutdown drains the server and runs the shutdown hooks, each step gets its own timeout
+func (i *Instance) shutdown(server *http.Server, timeout time.Duration) error {
+	slog.Info("🛑 Shutting down robin server", slog.Duration("timeout", timeout))
+
+	var errs []error
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("⚠ In-flight calls did not finish in time, closing the remaining connections", slog.String("error", err.Error()))
+
+		if err := server.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	hooksCtx, cancelHooks := context.WithTimeout(context.Background(), timeout)
+	defer cancelHooks()
+
+	if err := i.robin.runShutdownHooks(hooksCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func shutdownTimeout(config *ServeOptions) time.Duration {
+	if config != nil && config.ShutdownTimeout > 0 {
+		return config.ShutdownTimeout
+	}
+
+	return DefaultShutdownTimeout
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
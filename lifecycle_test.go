@@ -0,0 +1,301 @@
+package robin_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.trulyao.dev/robin"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func Test_ServeContext(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(event string) robin.LifecycleHook {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+			return nil
+		}
+	}
+
+	started := make(chan struct{}, 2)
+
+	instance, err := r.
+		OnStart(record("start")).
+		OnShutdown(record("close db")).
+		OnShutdown(record("close cache")).
+		Add(robin.Query("slow", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			started <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+			return "done", nil
+		})).
+		Add(robin.Subscription("forever", func(ctx *robin.Context, _ robin.Void, sink *robin.Sink[int]) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	port := freePort(t)
+	url := fmt.Sprintf("http://127.0.0.1:%d/_robin?%s=", port, robin.ProcNameKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- instance.ServeContext(ctx, robin.ServeOptions{Port: port, Route: "_robin", ShutdownTimeout: 5 * time.Second})
+	}()
+
+	// Wait for the server to start listening
+	for attempt := 0; ; attempt++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			break
+		}
+
+		if attempt == 50 {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	subscription, err := http.Get(url + "s__forever")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer subscription.Body.Close()
+
+	slowResponse := make(chan string, 1)
+	go func() {
+		res, err := http.Post(url+"q__slow", "application/json", strings.NewReader(""))
+		if err != nil {
+			slowResponse <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+
+		body, _ := bufio.NewReader(res.Body).ReadString('\n')
+		slowResponse <- body
+	}()
+
+	<-started
+	<-started
+
+	shutdownStarted := time.Now()
+	cancel()
+
+	// The in-flight query is allowed to finish while the subscription is stopped so that it doesn't hold up the shutdown
+	if body := <-slowResponse; body != `{"data":"done","ok":true}` {
+		t.Errorf("expected the in-flight call to finish, got %s", body)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("server did not shut down")
+	}
+
+	if elapsed := time.Since(shutdownStarted); elapsed > 2*time.Second {
+		t.Errorf("expected the subscription to be stopped on shutdown, shutting down took %s", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if expected := "start,close cache,close db"; strings.Join(events, ",") != expected {
+		t.Errorf("expected hooks to run as %s, got %s", expected, strings.Join(events, ","))
+	}
+}
+
+func Test_ServeAgainAfterShutdown(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Subscription("tick", func(ctx *robin.Context, _ robin.Void, sink *robin.Sink[int]) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+				return sink.Send(1)
+			}
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	// The second server should not inherit the stop signal of the first one, otherwise its subscriptions would be stopped right away
+	for round := range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- instance.ServeListenerContext(ctx, listener, robin.ServeOptions{Route: "_robin"})
+		}()
+
+		res, err := http.Get("http://" + listener.Addr().String() + "/_robin?" + robin.ProcNameKey + "=s__tick")
+		if err != nil {
+			cancel()
+			t.Fatalf("[round %d] failed to subscribe: %v", round, err)
+		}
+
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if !strings.Contains(string(body), "event: data\ndata: 1\n\n") {
+			t.Errorf("[round %d] expected the subscription to send an event, got %q", round, body)
+		}
+
+		cancel()
+		if err := <-serveErr; err != nil {
+			t.Fatalf("[round %d] expected a clean shutdown, got %v", round, err)
+		}
+	}
+}
+
+func Test_ServeListener(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("ping", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "pong", nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "robin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- instance.ServeListenerContext(ctx, listener, robin.ServeOptions{Route: "_robin"})
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	res, err := client.Post("http://robin/_robin?"+robin.ProcNameKey+"=q__ping", "application/json", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("failed to call procedure: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if expected := `{"data":"pong","ok":true}`; string(body) != expected {
+		t.Errorf("expected body %s, got %s", expected, body)
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+}
+
+func Test_ActivationListeners(t *testing.T) {
+	// The listener is inherited by a copy of the test binary, the same way systemd passes it to the service it activates
+	if address := os.Getenv("ROBIN_TEST_ACTIVATION_ADDRESS"); address != "" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+		listeners, err := robin.ActivationListeners()
+		if err != nil {
+			t.Fatalf("failed to get activation listeners: %v", err)
+		}
+
+		if len(listeners) != 1 || listeners[0].Addr().String() != address {
+			t.Fatalf("expected a listener on %s, got %v", address, listeners)
+		}
+
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Errorf("expected the socket activation environment variables to be unset")
+		}
+
+		return
+	}
+
+	if listeners, err := robin.ActivationListeners(); err != nil || len(listeners) != 0 {
+		t.Fatalf("expected no listeners without socket activation, got %v (%v)", listeners, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_ActivationListeners$")
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1", "LISTEN_FDNAMES=http", "ROBIN_TEST_ACTIVATION_ADDRESS="+listener.Addr().String())
+	cmd.ExtraFiles = []*os.File{file}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("socket activated process failed: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,112 @@
+package robin_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_AroundMiddleware(t *testing.T) {
+	var (
+		calls    []string
+		attempts int
+	)
+
+	record := func(name string) robin.AroundMiddleware {
+		return func(ctx *robin.Context, next robin.Next) (any, error) {
+			calls = append(calls, name+":before")
+			result, err := next()
+			calls = append(calls, name+":after")
+			return result, err
+		}
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		UseAround("global", record("global")).
+		Use("plain", func(ctx *robin.Context) error {
+			calls = append(calls, "plain")
+			return nil
+		}).
+		Add(robin.Query("greet", func(ctx *robin.Context, name string) (string, error) {
+			calls = append(calls, "procedure")
+			return "Hello, " + name, nil
+		}).WithAroundMiddleware(
+			record("local"),
+			// Replace the result
+			func(ctx *robin.Context, next robin.Next) (any, error) {
+				result, err := next()
+				if err != nil {
+					return nil, err
+				}
+
+				return strings.ToUpper(result.(string)), nil
+			},
+		)).
+		Add(robin.Mutation("flaky", func(ctx *robin.Context, name string) (string, error) {
+			attempts++
+			if attempts < 3 {
+				return "", errors.New("try again")
+			}
+
+			return name, nil
+		}).WithAroundMiddleware(func(ctx *robin.Context, next robin.Next) (any, error) {
+			// Retry until the call succeeds, the payload is still available on every attempt
+			for {
+				result, err := next()
+				if err == nil {
+					return result, nil
+				}
+			}
+		}).ExcludeMiddleware("global", "plain")).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	t.Run("wraps the call and replaces the result", func(t *testing.T) {
+		calls = nil
+
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__greet", strings.NewReader(`{"d": "John"}`))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if expected := `{"data":"HELLO, JOHN","ok":true}`; rec.Body.String() != expected {
+			t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+		}
+
+		expected := []string{"global:before", "local:before", "plain", "procedure", "local:after", "global:after"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Errorf("expected calls %v, got %v", expected, calls)
+		}
+	})
+
+	t.Run("can call next multiple times", func(t *testing.T) {
+		calls = nil
+
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=m__flaky", strings.NewReader(`{"d": "John"}`))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if expected := `{"data":"John","ok":true}`; rec.Body.String() != expected {
+			t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+		}
+
+		if attempts != 3 {
+			t.Errorf("expected 3 attempts, got %d", attempts)
+		}
+
+		if len(calls) != 0 {
+			t.Errorf("expected the excluded global middleware not to run, got %v", calls)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package robin
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"go.trulyao.dev/robin/internal/guarded"
 	"go.trulyao.dev/robin/types"
@@ -26,6 +27,7 @@ func Mutation[R any, B any](name string, fn ProcedureFn[R, B]) *mutation[R, B] {
 		},
 	}
 	m.alias = m.NormalizeProcedureName()
+	m.detectStream()
 
 	return m
 }
@@ -76,6 +78,12 @@ func (m *mutation[_, _]) NormalizeProcedureName() string {
 	return alias
 }
 
+// WithTimeout sets the maximum duration the mutation (including its middleware) is allowed to run for, pass `NoTimeout` to disable the default timeout
+func (m *mutation[_, _]) WithTimeout(timeout time.Duration) Procedure {
+	m.timeout = timeout
+	return m
+}
+
 // WithAlias sets the alias of the query
 func (m *mutation[_, _]) WithAlias(alias string) Procedure {
 	m.alias = alias
@@ -84,7 +92,7 @@ func (m *mutation[_, _]) WithAlias(alias string) Procedure {
 
 // Calls the mutation with the given context and body
 func (m *mutation[ReturnType, BodyType]) Call(ctx *Context, rawBody any) (any, error) {
-	body, err := guarded.CastType(rawBody, m.in.InferredType())
+	body, err := m.castPayload(rawBody)
 	if err != nil {
 		return nil, err
 	}
@@ -93,7 +101,12 @@ func (m *mutation[ReturnType, BodyType]) Call(ctx *Context, rawBody any) (any, e
 		return nil, RobinError{Reason: fmt.Sprintf("Procedure %s has no function attached", m.name)}
 	}
 
-	return m.fn(ctx, body)
+	out, err := m.fn(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.toResult(ctx, out), nil
 }
 
 // Validate validates the query
@@ -113,7 +126,11 @@ func (m *mutation[_, _]) Validate() error {
 		}
 	}
 
-	return nil
+	if err := m.validateDeclaredErrors(); err != nil {
+		return err
+	}
+
+	return m.validatePayloadRules()
 }
 
 // MiddlewareFns returns the middleware functions that should be executed before the mutation is called
@@ -133,6 +150,54 @@ func (m *mutation[_, _]) WithMiddleware(fns ...types.Middleware) Procedure {
 	return m
 }
 
+// PrependAroundMiddleware sets around middleware for the mutation at the beginning of the chain
+func (m *mutation[_, _]) PrependAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	m.aroundMiddlewareFns = append(fns, m.aroundMiddlewareFns...)
+	return m
+}
+
+// WithAroundMiddleware adds middleware that wrap the mutation call, they run before the regular middleware functions and can act on the result
+func (m *mutation[_, _]) WithAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	m.aroundMiddlewareFns = append(m.aroundMiddlewareFns, fns...)
+	return m
+}
+
+// WithGuard adds guards that are executed after the payload has been decoded and validated, right before the mutation is called
+func (m *mutation[_, _]) WithGuard(guards ...types.Guard) Procedure {
+	m.guards = append(m.guards, guards...)
+	return m
+}
+
+// WithTypedGuard adds a guard that receives the payload as the type the mutation expects
+func (m *mutation[ReturnType, ParamsType]) WithTypedGuard(fn func(ctx *Context, payload ParamsType) error) *mutation[ReturnType, ParamsType] {
+	m.guards = append(m.guards, typedGuard(fn))
+	return m
+}
+
+// RequireKeys declares the keys that the mutation needs its middleware to set, building the instance fails if none of them do
+func (m *mutation[_, _]) RequireKeys(keys ...types.ContextKey) Procedure {
+	m.requiredKeys = append(m.requiredKeys, keys...)
+	return m
+}
+
+// ProvideKeys declares the keys that the mutation's own middleware set
+func (m *mutation[_, _]) ProvideKeys(keys ...types.ContextKey) Procedure {
+	m.providedKeys = append(m.providedKeys, keys...)
+	return m
+}
+
+// Errors declares the errors the mutation can return, see `types.CodedError`
+func (m *mutation[_, _]) Errors(errs ...types.CodedError) Procedure {
+	m.declaredErrors = append(m.declaredErrors, errs...)
+	return m
+}
+
+// WithErrorHandler sets an error handler for the mutation's errors that takes precedence over the global one
+func (m *mutation[_, _]) WithErrorHandler(handler types.ContextErrorHandler) Procedure {
+	m.errorHandler = handler
+	return m
+}
+
 // ExcludeMiddleware takes a list of global middleware names and excludes them from the mutation
 func (m *mutation[_, _]) ExcludeMiddleware(names ...string) types.Procedure {
 	m.excludedMiddleware.AddMany(names)
@@ -2,7 +2,11 @@ package robin_test
 
 import (
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"go.trulyao.dev/robin"
@@ -79,3 +83,78 @@ func Test_MutationWithRawPayload(t *testing.T) {
 		t.Errorf("expected %v, got %v", userType, reflect.TypeOf(payload))
 	}
 }
+
+func Test_MutationGuards(t *testing.T) {
+	type UpdateTodo struct {
+		ID      int    `json:"id"`
+		OwnerID int    `json:"owner_id"`
+		Title   string `json:"title"`
+	}
+
+	var calls []string
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Mutation("update_todo", func(ctx *robin.Context, body UpdateTodo) (string, error) {
+			calls = append(calls, "procedure")
+			return body.Title, nil
+		}).
+			WithTypedGuard(func(ctx *robin.Context, body UpdateTodo) error {
+				calls = append(calls, "typed guard")
+				if ctx.Header("X-User-ID") != strconv.Itoa(body.OwnerID) {
+					return robin.Error{Message: "You can only edit your own todos", Code: http.StatusForbidden}
+				}
+
+				return nil
+			}).
+			WithGuard(func(ctx *robin.Context, payload any) error {
+				calls = append(calls, "guard")
+				if _, ok := payload.(UpdateTodo); !ok {
+					t.Errorf("expected the guard to receive an UpdateTodo, got %T", payload)
+				}
+
+				return nil
+			}).
+			WithMiddleware(func(ctx *robin.Context) error {
+				calls = append(calls, "middleware")
+				return nil
+			})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		userID      string
+		status      int
+		calls       []string
+	}{
+		{"owner passes the guards", "1", http.StatusOK, []string{"middleware", "typed guard", "guard", "procedure"}},
+		{"other users are rejected", "2", http.StatusForbidden, []string{"middleware", "typed guard"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			calls = nil
+
+			body := `{"d": {"id": 1, "owner_id": 1, "title": "Buy milk"}}`
+			req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=m__update_todo", strings.NewReader(body))
+			req.Header.Set("X-User-ID", test.userID)
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("expected calls %v, got %v", test.calls, calls)
+			}
+		})
+	}
+}
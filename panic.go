@@ -0,0 +1,85 @@
+package robin
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"go.trulyao.dev/robin/types"
+)
+
+type (
+	// PanicHandler is called with the recovered value and the stack trace of the goroutine that panicked whenever a panic is trapped (e.g. to report it to an error tracker)
+	PanicHandler func(ctx *Context, recovered any, stack []byte)
+
+	// PanicError is the original error of the `RobinError` a trapped panic is converted into
+	PanicError struct {
+		Value any
+		Stack []byte
+	}
+
+	// capturedPanic carries a panic from the goroutine it happened in to the one it is handled in without losing the stack trace
+	capturedPanic struct {
+		value any
+		stack []byte
+	}
+)
+
+func (pe PanicError) Error() string {
+	return fmt.Sprintf("Panic trapped: %v", pe.Value)
+}
+
+func (cp capturedPanic) String() string {
+	return fmt.Sprintf("%v\n\n%s", cp.value, cp.stack)
+}
+
+// capturePanic wraps a recovered value with the current stack trace so that it can be panicked with again somewhere else
+//
+// NOTE: this has to be called from the deferred function that recovered the panic
+func capturePanic(recovered any) capturedPanic {
+	if captured, ok := recovered.(capturedPanic); ok {
+		return captured
+	}
+
+	return capturedPanic{value: recovered, stack: debug.Stack()}
+}
+
+// makePanicError reports a trapped panic to the panic handler and converts it into an error that can be sent to the client, the stack trace is only included in the response in debug mode if enabled
+//
+// NOTE: this has to be called from the deferred function that recovered the panic
+func (r *Robin) makePanicError(ctx *Context, recovered any) error {
+	captured := capturePanic(recovered)
+
+	slog.Error(
+		"Panic trapped",
+		slog.Any("panic", captured.value),
+		slog.String("procedureName", ctx.ProcedureName()),
+		slog.String("stack", string(captured.stack)),
+	)
+
+	if r.panicHandler != nil {
+		func() {
+			// A broken panic handler should not take the server down with it
+			defer func() {
+				if e := recover(); e != nil {
+					slog.Error("Panic handler panicked", slog.Any("panic", e))
+				}
+			}()
+
+			r.panicHandler(ctx, captured.value, captured.stack)
+		}()
+	}
+
+	err := PanicError{Value: captured.value, Stack: captured.stack}
+	if r.debug && r.exposePanicStack && !r.production {
+		return types.Error{
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+			Cause:   err,
+			Meta:    map[string]any{"stack": string(captured.stack)},
+		}
+	}
+
+	return types.RobinError{Reason: err.Error(), OriginalError: err}
+}
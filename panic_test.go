@@ -0,0 +1,89 @@
+package robin_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_PanicHandler(t *testing.T) {
+	var (
+		recoveredValues []any
+		stacks          []string
+	)
+
+	r, err := robin.New(robin.Options{
+		EnableDebugMode:  true,
+		TrapPanic:        true,
+		ExposePanicStack: true,
+		PanicHandler: func(ctx *robin.Context, recovered any, stack []byte) {
+			recoveredValues = append(recoveredValues, recovered)
+			stacks = append(stacks, string(stack))
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("explode", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			panic("something broke")
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	instance.AttachRestEndpoints(mux, &robin.RestApiOptions{Enable: true})
+
+	requests := map[string]func(w http.ResponseWriter){
+		"rpc": func(w http.ResponseWriter) {
+			instance.Handler()(w, httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__explode", strings.NewReader("")))
+		},
+		"rest": func(w http.ResponseWriter) {
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/explode", nil))
+		},
+	}
+
+	for transport, request := range requests {
+		t.Run(transport, func(t *testing.T) {
+			recoveredValues, stacks = nil, nil
+
+			rec := httptest.NewRecorder()
+			request(rec)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("expected status code 500, got %d", rec.Code)
+			}
+
+			if len(recoveredValues) != 1 || recoveredValues[0] != "something broke" {
+				t.Fatalf("expected the panic handler to be called once with the recovered value, got %v", recoveredValues)
+			}
+
+			// The stack has to point at the procedure that panicked, not at the handler that recovered it
+			if !strings.Contains(stacks[0], "panic_test.go") {
+				t.Errorf("expected the stack trace to include the procedure, got %s", stacks[0])
+			}
+
+			var response struct {
+				Error robin.ErrorDetails `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if response.Error.Message != "Panic trapped: something broke" {
+				t.Errorf("expected the panic message, got %q", response.Error.Message)
+			}
+
+			if stack, _ := response.Error.Meta["stack"].(string); !strings.Contains(stack, "panic_test.go") {
+				t.Errorf("expected the stack trace in the response, got %v", response.Error.Meta)
+			}
+		})
+	}
+}
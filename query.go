@@ -3,6 +3,7 @@ package robin
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"go.trulyao.dev/robin/internal/guarded"
 	"go.trulyao.dev/robin/types"
@@ -26,6 +27,7 @@ func Query[R any, B any](name string, fn ProcedureFn[R, B]) *query[R, B] {
 		},
 	}
 	q.alias = q.NormalizeProcedureName()
+	q.detectStream()
 
 	return q
 }
@@ -76,6 +78,12 @@ func (q *query[_, _]) NormalizeProcedureName() string {
 	return alias
 }
 
+// WithTimeout sets the maximum duration the query (including its middleware) is allowed to run for, pass `NoTimeout` to disable the default timeout
+func (q *query[_, _]) WithTimeout(timeout time.Duration) Procedure {
+	q.timeout = timeout
+	return q
+}
+
 // WithAlias sets the alias of the query
 func (q *query[_, _]) WithAlias(alias string) Procedure {
 	q.alias = alias
@@ -84,7 +92,7 @@ func (q *query[_, _]) WithAlias(alias string) Procedure {
 
 // Calls the query with the given context and params
 func (q *query[ReturnType, ParamsType]) Call(ctx *Context, rawParams any) (any, error) {
-	params, err := guarded.CastType(rawParams, q.in.InferredType())
+	params, err := q.castPayload(rawParams)
 	if err != nil {
 		return nil, err
 	}
@@ -93,7 +101,12 @@ func (q *query[ReturnType, ParamsType]) Call(ctx *Context, rawParams any) (any,
 		return nil, RobinError{Reason: fmt.Sprintf("Procedure %s has no function attached", q.name)}
 	}
 
-	return q.fn(ctx, params)
+	out, err := q.fn(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.toResult(ctx, out), nil
 }
 
 // ExpectsPayload returns whether the query expects a payload or not
@@ -118,7 +131,11 @@ func (q *query[_, _]) Validate() error {
 		}
 	}
 
-	return nil
+	if err := q.validateDeclaredErrors(); err != nil {
+		return err
+	}
+
+	return q.validatePayloadRules()
 }
 
 // MiddlewareFns returns the middleware functions to be executed before the query is called
@@ -138,6 +155,54 @@ func (q *query[_, _]) WithMiddleware(fns ...types.Middleware) Procedure {
 	return q
 }
 
+// PrependAroundMiddleware sets around middleware for the query at the beginning of the chain
+func (q *query[_, _]) PrependAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	q.aroundMiddlewareFns = append(fns, q.aroundMiddlewareFns...)
+	return q
+}
+
+// WithAroundMiddleware adds middleware that wrap the query call, they run before the regular middleware functions and can act on the result
+func (q *query[_, _]) WithAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	q.aroundMiddlewareFns = append(q.aroundMiddlewareFns, fns...)
+	return q
+}
+
+// WithGuard adds guards that are executed after the payload has been decoded and validated, right before the query is called
+func (q *query[_, _]) WithGuard(guards ...types.Guard) Procedure {
+	q.guards = append(q.guards, guards...)
+	return q
+}
+
+// WithTypedGuard adds a guard that receives the payload as the type the query expects
+func (q *query[ReturnType, ParamsType]) WithTypedGuard(fn func(ctx *Context, payload ParamsType) error) *query[ReturnType, ParamsType] {
+	q.guards = append(q.guards, typedGuard(fn))
+	return q
+}
+
+// RequireKeys declares the keys that the query needs its middleware to set, building the instance fails if none of them do
+func (q *query[_, _]) RequireKeys(keys ...types.ContextKey) Procedure {
+	q.requiredKeys = append(q.requiredKeys, keys...)
+	return q
+}
+
+// ProvideKeys declares the keys that the query's own middleware set
+func (q *query[_, _]) ProvideKeys(keys ...types.ContextKey) Procedure {
+	q.providedKeys = append(q.providedKeys, keys...)
+	return q
+}
+
+// Errors declares the errors the query can return, see `types.CodedError`
+func (q *query[_, _]) Errors(errs ...types.CodedError) Procedure {
+	q.declaredErrors = append(q.declaredErrors, errs...)
+	return q
+}
+
+// WithErrorHandler sets an error handler for the query's errors that takes precedence over the global one
+func (q *query[_, _]) WithErrorHandler(handler types.ContextErrorHandler) Procedure {
+	q.errorHandler = handler
+	return q
+}
+
 // ExcludeMiddleware takes a list of global middleware names and excludes them from the query
 func (q *query[_, _]) ExcludeMiddleware(names ...string) types.Procedure {
 	q.excludedMiddleware.AddMany(names)
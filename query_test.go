@@ -2,8 +2,14 @@ package robin_test
 
 import (
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"go.trulyao.dev/robin"
 	"go.trulyao.dev/robin/types"
@@ -88,3 +94,231 @@ func Test_QueryWithRawPayload(t *testing.T) {
 		t.Errorf("expected %v, got %v", userType, reflect.TypeOf(payload))
 	}
 }
+
+func Test_QueryOverGET(t *testing.T) {
+	type Payload struct {
+		Name string `json:"name"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("greet", func(ctx *robin.Context, body Payload) (string, error) {
+			return "Hello, " + body.Name, nil
+		})).
+		Add(robin.Mutation("greet", func(ctx *robin.Context, body Payload) (string, error) {
+			return "Bye, " + body.Name, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		proc        string
+		payload     string
+		status      int
+		body        string
+	}{
+		{"query with payload", "q__greet", `{"name":"John"}`, http.StatusOK, `{"data":"Hello, John","ok":true}`},
+		{"mutation is not allowed", "m__greet", `{"name":"John"}`, http.StatusMethodNotAllowed, ""},
+		{"invalid payload", "q__greet", `{"name":`, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			query := url.Values{robin.ProcNameKey: {test.proc}, robin.PayloadKey: {test.payload}}
+			req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if test.body != "" && rec.Body.String() != test.body {
+				t.Errorf("expected body %s, got %s", test.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_RawQueryOverGET(t *testing.T) {
+	type User struct {
+		ID int `json:"id"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("echo", func(ctx *robin.Context, body io.ReadCloser) (string, error) {
+			data, err := io.ReadAll(body)
+			return string(data), err
+		}).WithRawPayload(User{})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	call := func(req *http.Request) string {
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		return rec.Body.String()
+	}
+
+	post := call(httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__echo", strings.NewReader(`{"d":{"id":1}}`)))
+
+	query := url.Values{robin.ProcNameKey: {"q__echo"}, robin.PayloadKey: {`{"id":1}`}}
+	get := call(httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+
+	if post != get {
+		t.Errorf("expected the same response over GET as over POST, got %s and %s", get, post)
+	}
+}
+
+func Test_QueryPayloadDecoding(t *testing.T) {
+	type Event struct {
+		ID int64     `json:"id"`
+		At time.Time `json:"at"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("id", func(ctx *robin.Context, id int64) (string, error) {
+			return strconv.FormatInt(id, 10), nil
+		})).
+		Add(robin.Query("keys", func(ctx *robin.Context, body map[string]int) (int, error) {
+			return body["a"] + body["b"], nil
+		})).
+		Add(robin.Query("event", func(ctx *robin.Context, body *Event) (string, error) {
+			if body == nil {
+				return "nil", nil
+			}
+
+			return strconv.FormatInt(body.ID, 10) + "@" + body.At.UTC().Format(time.DateOnly), nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		proc        string
+		body        string
+		status      int
+		expected    string
+	}{
+		{"int64 without losing precision", "q__id", `{"d": 9007199254740993}`, http.StatusOK, `{"data":"9007199254740993","ok":true}`},
+		{"map payload", "q__keys", `{"d": {"a": 1, "b": 2}}`, http.StatusOK, `{"data":3,"ok":true}`},
+		{"pointer payload with a time.Time field", "q__event", `{"d": {"id": 1, "at": "2024-01-02T03:04:05Z"}}`, http.StatusOK, `{"data":"1@2024-01-02","ok":true}`},
+		{"null pointer payload", "q__event", `{"d": null}`, http.StatusOK, `{"data":"nil","ok":true}`},
+		{"mismatched type", "q__event", `{"d": {"id": "1"}}`, http.StatusBadRequest, ""},
+		{"malformed body", "q__id", `{"d": `, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"="+test.proc, strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if test.expected != "" && rec.Body.String() != test.expected {
+				t.Errorf("expected body %s, got %s", test.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_QueryPayloadErrors(t *testing.T) {
+	type Address struct {
+		Street string `json:"street"`
+	}
+
+	type User struct {
+		Name      string    `json:"name"`
+		Age       uint8     `json:"age"`
+		Addresses []Address `json:"addresses"`
+		JoinedAt  time.Time `json:"joined_at"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("user", func(ctx *robin.Context, body User) (string, error) {
+			return body.Name, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		body        string
+		status      int
+		expected    string
+	}{
+		{
+			"lists every offending field",
+			`{"d": {"name": 1, "age": 300, "addresses": [{"street": "a"}, {"street": true}], "joined_at": "yesterday"}}`,
+			http.StatusBadRequest,
+			`{"error":{"message":"Invalid payload provided: 4 values do not match the expected types","fields":[` +
+				`{"path":"/addresses/1/street","expected":"string","received":"boolean"},` +
+				`{"path":"/age","expected":"integer","received":"integer"},` +
+				`{"path":"/joined_at","expected":"date-time","received":"string"},` +
+				`{"path":"/name","expected":"string","received":"integer"}]},"ok":false}`,
+		},
+		{
+			"reports the payload itself",
+			`{"d": [1]}`,
+			http.StatusBadRequest,
+			`{"error":{"message":"Invalid payload provided: expected ` + "`object`, got `array`" + `","fields":[{"path":"","expected":"object","received":"array"}]},"ok":false}`,
+		},
+		{
+			"malformed payload",
+			`{"d": {"name": }}`,
+			http.StatusBadRequest,
+			`{"error":"Malformed payload provided","ok":false}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__user", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if rec.Body.String() != test.expected {
+				t.Errorf("expected body %s, got %s", test.expected, rec.Body.String())
+			}
+		})
+	}
+}
@@ -61,14 +61,39 @@ func (e Endpoints) String() string {
 
 // BuildProcedureHttpHandler builds an http handler for the given procedure
 func (i *Instance) BuildProcedureHttpHandler(procedure Procedure) http.HandlerFunc {
+	return i.buildProcedureHttpHandler(procedure, i.robin.errorFormat)
+}
+
+func (i *Instance) buildProcedureHttpHandler(procedure Procedure, errorFormat ErrorFormat) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		ctx := types.NewContext(req, &w)
 
 		ctx.SetProcedureName(procedure.Name())
 		ctx.SetProcedureType(procedure.Type())
 
-		if err := i.robin.handleProcedureCall(ctx, procedure); err != nil {
-			i.robin.sendError(w, err)
+		if i.robin.trapPanic {
+			defer func() {
+				if e := recover(); e != nil {
+					i.robin.sendErrorWithFormat(w, ctx, errorFormat, i.robin.makePanicError(ctx, e))
+				}
+			}()
+		}
+
+		// GET requests carry the payload in the URL like they do on the RPC endpoint, this is also what lets `EventSource` pass a payload to a subscription
+		if req.Method == http.MethodGet {
+			urlPayloadReq, err := i.robin.makeRequestFromURLPayload(req, procedure)
+			if err != nil {
+				i.robin.sendErrorWithFormat(w, ctx, errorFormat, err)
+				return
+			}
+
+			ctx = types.NewContext(urlPayloadReq, &w)
+			ctx.SetProcedureName(procedure.Name())
+			ctx.SetProcedureType(procedure.Type())
+		}
+
+		if err := i.robin.dispatchProcedureCall(ctx, procedure); err != nil {
+			i.robin.sendErrorWithFormat(w, ctx, errorFormat, err)
 			return
 		}
 	}
@@ -82,11 +107,16 @@ func (i *Instance) BuildProcedureHttpHandler(procedure Procedure) http.HandlerFu
 func (i *Instance) BuildRestEndpoints(
 	prefix string,
 ) Endpoints {
+	return i.buildRestEndpoints(prefix, i.robin.errorFormat)
+}
+
+func (i *Instance) buildRestEndpoints(prefix string, errorFormat ErrorFormat) Endpoints {
 	var endpoints []*RestEndpoint
 
 	prefix = trimUrlPath(prefix)
 
 	for _, procedure := range i.robin.procedures.List() {
+		// Subscriptions are served over GET so that they can be consumed with the browser's `EventSource`, the payload goes in the `d` query parameter
 		method := types.HttpMethodGet
 		if procedure.Type() == types.ProcedureTypeMutation {
 			method = types.HttpMethodPost
@@ -98,7 +128,7 @@ func (i *Instance) BuildRestEndpoints(
 			ProcedureName: procedure.Name(),
 			Path:          fmt.Sprintf("/%s/%s", prefix, alias),
 			Method:        method,
-			HandlerFunc:   i.BuildProcedureHttpHandler(procedure),
+			HandlerFunc:   i.buildProcedureHttpHandler(procedure, errorFormat),
 		}
 
 		endpoints = append(endpoints, endpoint)
@@ -121,7 +151,12 @@ func (i *Instance) AttachRestEndpoints(mux *http.ServeMux, opts *RestApiOptions)
 		prefix = "/api"
 	}
 
-	endpoints := i.BuildRestEndpoints(prefix)
+	errorFormat := opts.ErrorFormat
+	if errorFormat == "" {
+		errorFormat = i.robin.errorFormat
+	}
+
+	endpoints := i.buildRestEndpoints(prefix, errorFormat)
 	for _, endpoint := range endpoints {
 		if i.robin.Debug() {
 			slog.Info("🔗 Attaching RESTful endpoint", slog.String("endpoint", endpoint.String()))
@@ -137,7 +172,7 @@ func (i *Instance) AttachRestEndpoints(mux *http.ServeMux, opts *RestApiOptions)
 	// Attach the not found handler
 	if !opts.DisableNotFoundHandler {
 		mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-			i.robin.sendError(w, types.NewError("Resource not found", http.StatusNotFound))
+			i.robin.sendErrorWithFormat(w, types.NewContext(req, &w), errorFormat, types.NewError("Resource not found", http.StatusNotFound))
 		})
 	}
 
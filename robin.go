@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/agnivade/levenshtein"
+	"go.trulyao.dev/robin/codec"
 	"go.trulyao.dev/robin/types"
 )
 
@@ -24,18 +27,30 @@ type (
 	Procedure     = types.Procedure
 	Context       = types.Context
 	Middleware    = types.Middleware
+	Guard         = types.Guard
+	CodedError    = types.CodedError
+	ContextKey    = types.ContextKey
+
+	Next             = types.Next
+	AroundMiddleware = types.AroundMiddleware
 )
 
 // Re-exported constants
 const (
-	ProcedureTypeQuery    ProcedureType = types.ProcedureTypeQuery
-	ProcedureTypeMutation ProcedureType = types.ProcedureTypeMutation
+	ProcedureTypeQuery        ProcedureType = types.ProcedureTypeQuery
+	ProcedureTypeMutation     ProcedureType = types.ProcedureTypeMutation
+	ProcedureTypeSubscription ProcedureType = types.ProcedureTypeSubscription
+
+	NoTimeout = types.NoTimeout
 )
 
 const (
 	ProcSeparator = "__"
 	ProcNameKey   = ProcSeparator + "proc"
 
+	// The URL query key that holds the (JSON-encoded) payload for procedures called via GET requests
+	PayloadKey = "d"
+
 	// Environment variables to control code generation outside of the code
 	EnvEnableSchemaGen   = "ROBIN_ENABLE_SCHEMA_GEN"
 	EnvEnableBindingsGen = "ROBIN_ENABLE_BINDINGS_GEN"
@@ -60,6 +75,11 @@ var (
 	ReMutationWords = regexp.MustCompile(
 		`(?i)(^(create|add|insert|update|upsert|edit|modify|change|delete|remove|destroy)\.)`,
 	)
+
+	// Valid/common words associated with subscriptions
+	ReSubscriptionWords = regexp.MustCompile(
+		`(?i)(^(watch|subscribe|listen|observe|stream|on)\.)`,
+	)
 )
 
 type (
@@ -91,11 +111,44 @@ type (
 		// Enable debug mode to log useful info
 		EnableDebugMode bool
 
-		// Whether to enable panic trapping or not
+		// Whether to enable panic trapping or not, trapped panics are sent to the client as errors instead of crashing the handler
 		TrapPanic bool
 
+		// A function that is called with the context, recovered value and stack trace of every trapped panic (e.g. to report it to an error tracker)
+		//
+		// NOTE: this is only called if `TrapPanic` is enabled
+		PanicHandler PanicHandler
+
+		// Include the stack trace of trapped panics in error responses, this only has an effect in debug mode and never in production mode
+		ExposePanicStack bool
+
 		// A function that will be called when an error occurs, it should ideally return a marshallable struct
 		ErrorHandler ErrorHandler
+
+		// Same as `ErrorHandler` but with access to the context of the call, this takes precedence over `ErrorHandler` if both are set
+		ContextErrorHandler ContextErrorHandler
+
+		// Maps sentinel errors and error types to status codes and public codes, errors it matches are passed to the error handler as a `types.Error` with the original error as its cause
+		ErrorRegistry *ErrorRegistry
+
+		// How error responses are rendered (default is `ErrorFormatEnvelope`), this only applies to plain HTTP responses since batched calls, streams and WebSocket messages always use the envelope
+		ErrorFormat ErrorFormat
+
+		// Only send the details of errors that are explicitly public (see `types.PublicError`) to clients, every other error is replaced with a generic message and an error ID that is logged along with the full error
+		ProductionMode bool
+
+		// Options for controlling batched procedure calls
+		BatchOptions BatchOptions
+
+		// Codecs available for decoding payloads and encoding responses, the codec is picked based on the `Content-Type` and `Accept` headers (default is `DefaultCodecs`, which has JSON, MessagePack and CBOR), other wire formats can be added by implementing `Codec`
+		//
+		// NOTE: JSON is always available since it is used as the fallback, streamed results, batched calls (but not their results) and WebSocket messages are always JSON
+		Codecs []Codec
+
+		// The maximum duration a query or mutation (including its middleware) is allowed to run for unless the procedure sets its own timeout with `WithTimeout`, zero means no timeout
+		//
+		// NOTE: this does not apply to subscriptions since they are expected to be long-lived
+		DefaultTimeout time.Duration
 	}
 
 	GlobalMiddleware struct {
@@ -103,6 +156,11 @@ type (
 		Fn   Middleware
 	}
 
+	GlobalAroundMiddleware struct {
+		Name string
+		Fn   AroundMiddleware
+	}
+
 	Robin struct {
 		// Controls Typescript code generation
 		codegenOptions CodegenOptions
@@ -113,6 +171,12 @@ type (
 		// Whether to enable panic trapping or not
 		trapPanic bool
 
+		// Called with every trapped panic
+		panicHandler PanicHandler
+
+		// Whether to include the stack trace of trapped panics in error responses in debug mode
+		exposePanicStack bool
+
 		// A list of query and mutation procedures
 		procedures *Procedures
 
@@ -120,8 +184,42 @@ type (
 		// NOTE: a slice has been used instead of a map to maintain the order of insertion as this is crucial to the order of execution for some middlewares
 		namedGlobalMiddleware []GlobalMiddleware
 
+		// Same as `namedGlobalMiddleware` but for middleware that wrap the whole call
+		namedGlobalAroundMiddleware []GlobalAroundMiddleware
+
+		// The keys that named global middleware declare they set
+		middlewareKeys map[string][]ContextKey
+
 		// A function that will be called when an error occurs, if not provided, the default error handler will be used
-		errorHandler ErrorHandler
+		errorHandler ContextErrorHandler
+
+		// Resolves errors to status codes and public codes before they are passed to the error handler
+		errorRegistry *ErrorRegistry
+
+		// Whether to redact errors that are not public
+		production bool
+
+		// How error responses are rendered
+		errorFormat ErrorFormat
+
+		// Options for controlling batched procedure calls
+		batchOptions BatchOptions
+
+		// The default maximum duration a query or mutation is allowed to run for
+		defaultTimeout time.Duration
+
+		// Codecs available for content negotiation
+		codecs []Codec
+
+		// Application-level services available to every procedure call via `Service`
+		services *serviceContainer
+
+		// Hooks run before the server starts listening and after it has shut down
+		startHooks    []LifecycleHook
+		shutdownHooks []LifecycleHook
+
+		// Stops long-lived calls (subscriptions, WebSocket connections) when the server starts shutting down
+		stopping *stopSignal
 	}
 )
 
@@ -129,9 +227,16 @@ type (
 func New(opts Options) (*Robin, error) {
 	robin := new(Robin)
 
-	errorHandler := DefaultErrorHandler
-	if opts.ErrorHandler != nil {
-		errorHandler = opts.ErrorHandler
+	errorHandler := opts.ContextErrorHandler
+	if errorHandler == nil {
+		handler := DefaultErrorHandler
+		if opts.ErrorHandler != nil {
+			handler = opts.ErrorHandler
+		}
+
+		errorHandler = func(_ *Context, err error) (Serializable, int) {
+			return handler(err)
+		}
 	}
 
 	codegenOptions, err := robin.extractCodegenOptions(&opts)
@@ -139,12 +244,31 @@ func New(opts Options) (*Robin, error) {
 		return nil, err
 	}
 
+	codecs := opts.Codecs
+	if len(codecs) == 0 {
+		codecs = DefaultCodecs
+	}
+
+	if !slices.Contains(codecs, codec.JSON) {
+		codecs = append([]Codec{codec.JSON}, codecs...)
+	}
+
 	robin = &Robin{
-		codegenOptions: codegenOptions,
-		debug:          opts.EnableDebugMode,
-		trapPanic:      opts.TrapPanic,
-		procedures:     &Procedures{},
-		errorHandler:   errorHandler,
+		codegenOptions:   codegenOptions,
+		debug:            opts.EnableDebugMode,
+		trapPanic:        opts.TrapPanic,
+		panicHandler:     opts.PanicHandler,
+		exposePanicStack: opts.ExposePanicStack,
+		procedures:       &Procedures{},
+		errorHandler:     errorHandler,
+		errorRegistry:    opts.ErrorRegistry,
+		production:       opts.ProductionMode,
+		errorFormat:      opts.ErrorFormat,
+		batchOptions:     opts.BatchOptions,
+		defaultTimeout:   opts.DefaultTimeout,
+		codecs:           codecs,
+		services:         newServiceContainer(),
+		stopping:         newStopSignal(),
 	}
 
 	return robin, nil
@@ -196,6 +320,20 @@ func (r *Robin) Use(name string, middleware Middleware) *Robin {
 	return r
 }
 
+// UseAround adds a global middleware that wraps every procedure call unless explicitly excluded/opted out of, it can run code before and after the call and act on its result (e.g. for timing, caching or transactions)
+// The first middleware added is the outermost one
+//
+// WARNING: Around middleware ALWAYS wrap the regular middleware functions (global or not), and global around middleware wrap the procedure's own around middleware
+//
+// NOTE: Around middleware share the same names as regular middleware for exclusion, so `procedure.ExcludeMiddleware(...)` works for both
+func (r *Robin) UseAround(name string, middleware AroundMiddleware) *Robin {
+	r.namedGlobalAroundMiddleware = append(
+		r.namedGlobalAroundMiddleware,
+		GlobalAroundMiddleware{Name: name, Fn: middleware},
+	)
+	return r
+}
+
 // Build the Robin instance
 func (r *Robin) Build() (*Instance, error) {
 	// Validate all procedures
@@ -210,9 +348,16 @@ func (r *Robin) Build() (*Instance, error) {
 
 		// Check if we have excluded a wildcard middleware
 		if procedure.ExcludedMiddleware().Has("*") {
+			if err := r.checkRequiredKeys(procedure, nil); err != nil {
+				return nil, err
+			}
+
 			continue
 		}
 
+		// The names of the global middleware that apply to the procedure, used to check that the keys it requires are provided
+		var appliedMiddlewareNames []string
+
 		var globalMiddleware []Middleware // This is to maintain the order of execution, attempting to prepending in the loop will reverse the order
 		// Add global middleware to the procedures
 		for _, middleware := range r.namedGlobalMiddleware {
@@ -221,16 +366,34 @@ func (r *Robin) Build() (*Instance, error) {
 			}
 
 			globalMiddleware = append(globalMiddleware, middleware.Fn)
+			appliedMiddlewareNames = append(appliedMiddlewareNames, middleware.Name)
 		}
 
 		// Prepend global middleware to the procedure's middleware chain
 		procedure.PrependMiddleware(globalMiddleware...)
 
+		var globalAroundMiddleware []AroundMiddleware
+		for _, middleware := range r.namedGlobalAroundMiddleware {
+			if procedure.ExcludedMiddleware().Has(middleware.Name) {
+				continue
+			}
+
+			globalAroundMiddleware = append(globalAroundMiddleware, middleware.Fn)
+			appliedMiddlewareNames = append(appliedMiddlewareNames, middleware.Name)
+		}
+
+		if err := r.checkRequiredKeys(procedure, appliedMiddlewareNames); err != nil {
+			return nil, err
+		}
+
+		procedure.PrependAroundMiddleware(globalAroundMiddleware...)
+
 		if r.debug {
 			slog.Info(
 				"Global middleware added to procedure",
 				slog.String("procedureName", procedure.Name()),
 				slog.Int("middlewareCount", len(globalMiddleware)),
+				slog.Int("aroundMiddlewareCount", len(globalAroundMiddleware)),
 			)
 		}
 
@@ -257,59 +420,85 @@ func (r *Robin) Build() (*Instance, error) {
 // serveHTTP is the main handler for all incoming HTTP requests
 // It takes the request, and transforms it into a Robin Context, then calls the appropriate procedure if present
 func (r *Robin) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	// The context is created upfront so that errors that occur before the procedure is called are handled with it too
+	ctx := types.NewContext(req, &w)
+
 	if r.trapPanic {
-		defer func(r *Robin) {
+		defer func() {
 			if e := recover(); e != nil {
-				r.sendError(w, types.RobinError{Reason: fmt.Sprintf("Panic trapped: %v", e)})
+				r.sendError(w, ctx, r.makePanicError(ctx, e))
 			}
-		}(r)
+		}()
 	}
 
 	defer req.Body.Close()
 
-	var err error
+	// Batched calls carry their own procedure names and types in the body
+	if req.URL.Query().Has(BatchKey) {
+		if err := r.handleBatchCall(w, req); err != nil {
+			r.sendError(w, ctx, err)
+		}
+		return
+	}
 
-	ctx := types.NewContext(req, &w)
 	procedureType, procedureName, err := r.getProcedureMetaFromURL(req.URL)
 	if err != nil {
-		r.sendError(w, err)
+		r.sendError(w, ctx, err)
 		return
 	}
 
 	ctx.SetProcedureName(procedureName)
 	ctx.SetProcedureType(procedureType)
 
-	procedure, found := r.findProcedure(ctx.ProcedureName(), ctx.ProcedureType())
+	procedure, found := r.findProcedure(procedureName, procedureType)
 	if !found {
-		r.sendError(
-			w,
-			r.makeMissingProcedureError(ctx.ProcedureName(), ctx.ProcedureType()),
-		)
+		r.sendError(w, ctx, r.makeMissingProcedureError(procedureName, procedureType))
 		return
 	}
 
-	switch ProcedureType(ctx.ProcedureType()) {
-	case ProcedureTypeQuery, ProcedureTypeMutation:
-		err := r.handleProcedureCall(ctx, procedure)
+	// Queries (and subscriptions) can also be issued via GET requests so that they can be cached, the payload is read from the URL instead of the body
+	if req.Method == http.MethodGet {
+		urlPayloadReq, err := r.makeRequestFromURLPayload(req, procedure)
 		if err != nil {
-			r.sendError(w, err)
+			r.sendError(w, ctx, err)
 			return
 		}
 
-	default:
-		r.sendError(
-			w,
-			types.RobinError{
-				Reason: fmt.Sprintf(
-					"Invalid procedure type, expect one of 'query' or 'mutation', got %s",
-					string(ctx.ProcedureType()),
-				),
-			},
-		)
+		ctx = types.NewContext(urlPayloadReq, &w)
+		ctx.SetProcedureName(procedureName)
+		ctx.SetProcedureType(procedureType)
+	}
+
+	if err := r.dispatchProcedureCall(ctx, procedure); err != nil {
+		r.sendError(w, ctx, err)
 		return
 	}
 }
 
+// dispatchProcedureCall hands the procedure call over to the appropriate handler based on the type of the procedure
+func (r *Robin) dispatchProcedureCall(ctx *Context, procedure Procedure) error {
+	// Streaming procedures can run for as long as the client is connected, so they are stopped on shutdown instead of holding it up
+	if procedure.IsStreaming() {
+		defer r.stopOnShutdown(ctx)()
+	}
+
+	switch ProcedureType(ctx.ProcedureType()) {
+	case ProcedureTypeQuery, ProcedureTypeMutation:
+		return r.handleProcedureCall(ctx, procedure)
+
+	case ProcedureTypeSubscription:
+		return r.handleSubscription(ctx, procedure)
+
+	default:
+		return types.RobinError{
+			Reason: fmt.Sprintf(
+				"Invalid procedure type, expect one of 'query', 'mutation' or 'subscription', got %s",
+				string(ctx.ProcedureType()),
+			),
+		}
+	}
+}
+
 // makeMissingProcedureError creates an error that indicates that a procedure was not found and suggests the closest procedure name if any
 func (r *Robin) makeMissingProcedureError(procedureName string, procedureType ProcedureType) error {
 	var (
@@ -348,14 +537,35 @@ func (r *Robin) makeMissingProcedureError(procedureName string, procedureType Pr
 }
 
 // Utility function to send an error response
-func (r *Robin) sendError(w http.ResponseWriter, err error) {
+//
+// NOTE: the response is written to w rather than the context's response writer since the latter may have been handed to a procedure that is still running (e.g. after a timeout)
+func (r *Robin) sendError(w http.ResponseWriter, ctx *Context, err error) {
+	r.sendErrorWithFormat(w, ctx, r.errorFormat, err)
+}
+
+// sendErrorWithFormat is `sendError` with the format of the response overridden (e.g. for RESTful endpoints)
+func (r *Robin) sendErrorWithFormat(w http.ResponseWriter, ctx *Context, format ErrorFormat, err error) {
 	if r.debug {
 		slog.Error("An error occurred in handler", slog.Any("error", err))
 	}
 
-	errorResponse, code := r.errorHandler(err)
-	errMap := map[string]any{"error": errorResponse, "ok": false}
-	jsonResp, err := json.Marshal(errMap)
+	req := ctx.Request()
+	errorResponse, code := r.handleError(ctx, err)
+
+	var (
+		resp        []byte
+		contentType string
+	)
+
+	if format == ErrorFormatProblemJSON {
+		resp, err = json.Marshal(makeProblemDetails(req, errorResponse, code))
+		contentType = ProblemJSONContentType
+	} else {
+		c := r.responseCodec(req)
+		resp, err = c.Marshal(map[string]any{"error": errorResponse, "ok": false})
+		contentType = c.ContentType()
+	}
+
 	if err != nil {
 		slog.Error("Failed to marshal error response", slog.String("error", err.Error()))
 
@@ -367,9 +577,9 @@ func (r *Robin) sendError(w http.ResponseWriter, err error) {
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(code)
-	if _, err := w.Write([]byte(jsonResp)); err != nil {
+	if _, err := w.Write(resp); err != nil {
 		slog.Error("Failed to write response", slog.String("error", err.Error()))
 	}
 }
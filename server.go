@@ -0,0 +1,103 @@
+package robin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Server returns the `http.Server` that `Serve` and `ServeContext` would run with the given options, this is useful for advanced use cases that need to start (or further configure) the server themselves
+//
+// NOTE: lifecycle hooks are only run by `Serve` and `ServeContext`, but shutting the returned server down still stops subscriptions and WebSocket connections
+func (i *Instance) Server(opts ...ServeOptions) (*http.Server, error) {
+	var config *ServeOptions
+	if len(opts) > 0 {
+		config = &opts[0]
+	}
+
+	server, _, err := i.server(config)
+	return server, err
+}
+
+// server builds the server for the options and returns the route the robin handler is served on alongside it
+func (i *Instance) server(config *ServeOptions) (*http.Server, string, error) {
+	port, route, err := i.resolveAddress(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	handler := i.buildHandler(config, route)
+
+	// Every server gets the signal that is current when it is created, so shutting down one that was served before does not affect this one
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(i.robin.stopping.arm())
+
+	if config == nil {
+		server.Addr = net.JoinHostPort("", strconv.Itoa(port))
+		return server, route, nil
+	}
+
+	server.Addr = net.JoinHostPort(config.Host, strconv.Itoa(port))
+	server.ReadTimeout = config.ReadTimeout
+	server.ReadHeaderTimeout = config.ReadHeaderTimeout
+	server.WriteTimeout = config.WriteTimeout
+	server.IdleTimeout = config.IdleTimeout
+	server.MaxHeaderBytes = config.MaxHeaderBytes
+
+	if config.TLS != nil {
+		tlsConfig, err := makeTLSConfig(config.TLS)
+		if err != nil {
+			return nil, "", err
+		}
+
+		server.TLSConfig = tlsConfig
+	}
+
+	return server, route, nil
+}
+
+// makeTLSConfig builds the TLS configuration from the options, loading the certificate and client CAs from disk
+func makeTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.Config != nil {
+		config = opts.Config.Clone()
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+
+		config.Certificates = append(config.Certificates, certificate)
+	}
+
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("no TLS certificate provided, set `CertFile` and `KeyFile` or provide a `Config` with certificates")
+	}
+
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no valid certificates found in the client CA file")
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if opts.ClientAuth != tls.NoClientCert {
+			config.ClientAuth = opts.ClientAuth
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,183 @@
+package robin_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.trulyao.dev/robin"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func makeTestCertificate(t *testing.T, commonName string, parent *testCertificate, template *x509.Certificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: commonName}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+func (c *testCertificate) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func Test_ServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := makeTestCertificate(t, "robin-ca", nil, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign})
+	serverCert := makeTestCertificate(t, "robin-server", ca, &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := makeTestCertificate(t, "robin-client", ca, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := serverCert.write(t, dir, "server")
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("whoami", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			if cert := ctx.ClientCertificate(); cert != nil {
+				return cert.Subject.CommonName, nil
+			}
+
+			return "anonymous", nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	server, err := instance.Server(robin.ServeOptions{
+		Host:        "127.0.0.1",
+		Port:        9443,
+		Route:       "_robin",
+		ReadTimeout: 5 * time.Second,
+		TLS:         &robin.TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if server.Addr != "127.0.0.1:9443" || server.ReadTimeout != 5*time.Second {
+		t.Errorf("expected the server to be configured from the options, got address %s and read timeout %s", server.Addr, server.ReadTimeout)
+	}
+
+	if server.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certificates to be required, got %v", server.TLSConfig.ClientAuth)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	go func() { _ = server.ServeTLS(listener, "", "") }()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	call := func(certificates []tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}}
+		defer client.CloseIdleConnections()
+
+		res, err := client.Post("https://"+listener.Addr().String()+"/_robin?"+robin.ProcNameKey+"=q__whoami", "application/json", strings.NewReader(""))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	body, err := call([]tls.Certificate{{Certificate: [][]byte{clientCert.der}, PrivateKey: clientCert.key}})
+	if err != nil {
+		t.Fatalf("failed to call procedure: %v", err)
+	}
+
+	if expected := `{"data":"robin-client","ok":true}`; body != expected {
+		t.Errorf("expected body %s, got %s", expected, body)
+	}
+
+	if _, err := call(nil); err == nil {
+		t.Errorf("expected the call without a client certificate to be rejected")
+	}
+}
+
+func Test_ServerWithoutCertificate(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	if _, err := instance.Server(robin.ServeOptions{TLS: &robin.TLSOptions{}}); err == nil {
+		t.Errorf("expected an error when TLS is enabled without a certificate")
+	}
+}
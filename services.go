@@ -0,0 +1,220 @@
+package robin
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"go.trulyao.dev/robin/types"
+)
+
+type (
+	serviceLifetime int
+
+	// serviceContainer holds the services provided to the robin instance, keyed by their types
+	serviceContainer struct {
+		providers map[reflect.Type]*serviceProvider
+	}
+
+	serviceProvider struct {
+		lifetime serviceLifetime
+
+		// Application-scoped services are constructed at most once and shared by every call
+		mu          sync.Mutex
+		value       any
+		constructed bool
+		construct   func() (any, error)
+
+		// Call-scoped services are constructed once per call
+		constructScoped func(ctx *Context) (any, func(), error)
+	}
+
+	// serviceScope holds the call-scoped services constructed for a single call and how to clean them up
+	serviceScope struct {
+		container *serviceContainer
+		ctx       *Context
+
+		mu        sync.Mutex
+		instances map[reflect.Type]any
+		cleanups  []func()
+	}
+
+	serviceScopeKey struct{}
+)
+
+const (
+	serviceSingleton serviceLifetime = iota
+	serviceLazy
+	serviceScoped
+)
+
+// Provide registers an application-scoped service (e.g. a database pool or config) that can be retrieved in any procedure or middleware with `Service`
+//
+// Services are keyed by their type, so providing another value of the same type replaces the previous one
+func Provide[T any](r *Robin, value T) *Robin {
+	r.services.add(reflect.TypeFor[T](), &serviceProvider{lifetime: serviceSingleton, value: value, constructed: true})
+	return r
+}
+
+// ProvideLazy registers an application-scoped service that is constructed the first time it is requested and shared after that, the constructor is retried on the next request if it fails
+func ProvideLazy[T any](r *Robin, constructor func() (T, error)) *Robin {
+	r.services.add(reflect.TypeFor[T](), &serviceProvider{
+		lifetime: serviceLazy,
+		construct: func() (any, error) {
+			return constructor()
+		},
+	})
+	return r
+}
+
+// ProvideScoped registers a service that is constructed at most once per call the first time it is requested (e.g. a database transaction), the cleanup function it returns (if any) is called once the procedure is done
+//
+// NOTE: for procedures that stream their result, the cleanup happens after the stream ends
+func ProvideScoped[T any](r *Robin, constructor func(ctx *Context) (T, func(), error)) *Robin {
+	r.services.add(reflect.TypeFor[T](), &serviceProvider{
+		lifetime: serviceScoped,
+		constructScoped: func(ctx *Context) (any, func(), error) {
+			return constructor(ctx)
+		},
+	})
+	return r
+}
+
+// LookupService returns the service of the given type, an error is returned if it has not been provided or could not be constructed
+func LookupService[T any](ctx *Context) (T, error) {
+	var zero T
+
+	scope, ok := ctx.Value(serviceScopeKey{}).(*serviceScope)
+	if !ok {
+		return zero, RobinError{Reason: "Services are only available in procedure calls", OriginalError: fmt.Errorf("no service scope in context")}
+	}
+
+	value, err := scope.get(reflect.TypeFor[T]())
+	if err != nil {
+		return zero, err
+	}
+
+	return value.(T), nil
+}
+
+// Service returns the service of the given type and panics if it has not been provided or could not be constructed, use `LookupService` to handle these cases
+func Service[T any](ctx *Context) T {
+	value, err := LookupService[T](ctx)
+	if err != nil {
+		panic(fmt.Sprintf("robin: %s", err))
+	}
+
+	return value
+}
+
+func newServiceContainer() *serviceContainer {
+	return &serviceContainer{providers: make(map[reflect.Type]*serviceProvider)}
+}
+
+func (c *serviceContainer) add(t reflect.Type, provider *serviceProvider) {
+	c.providers[t] = provider
+}
+
+// newScope creates a scope for a single call and attaches it to the context
+func (c *serviceContainer) newScope(ctx *Context) *serviceScope {
+	scope := &serviceScope{container: c, ctx: ctx}
+	ctx.WithValue(serviceScopeKey{}, scope)
+	return scope
+}
+
+func (s *serviceScope) get(t reflect.Type) (any, error) {
+	provider, ok := s.container.providers[t]
+	if !ok {
+		return nil, RobinError{Reason: fmt.Sprintf("No service of type `%s` has been provided", t), OriginalError: fmt.Errorf("missing service %s", t)}
+	}
+
+	if provider.lifetime != serviceScoped {
+		return provider.get(t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value, ok := s.instances[t]; ok {
+		return value, nil
+	}
+
+	value, cleanup, err := provider.constructScoped(s.ctx)
+	if err != nil {
+		return nil, RobinError{Reason: fmt.Sprintf("Failed to construct service of type `%s`", t), OriginalError: err}
+	}
+
+	if s.instances == nil {
+		s.instances = make(map[reflect.Type]any)
+	}
+
+	s.instances[t] = value
+	if cleanup != nil {
+		s.cleanups = append(s.cleanups, cleanup)
+	}
+
+	return value, nil
+}
+
+// close cleans up the call-scoped services in the reverse order they were constructed
+func (s *serviceScope) close() {
+	s.mu.Lock()
+	cleanups := s.cleanups
+	s.cleanups = nil
+	s.mu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		func() {
+			// A failing cleanup should not prevent the others from running
+			defer func() {
+				if e := recover(); e != nil {
+					slog.Error("Service cleanup panicked", slog.Any("error", e))
+				}
+			}()
+
+			cleanups[i]()
+		}()
+	}
+}
+
+func (p *serviceProvider) get(t reflect.Type) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.constructed {
+		return p.value, nil
+	}
+
+	value, err := p.construct()
+	if err != nil {
+		return nil, RobinError{Reason: fmt.Sprintf("Failed to construct service of type `%s`", t), OriginalError: err}
+	}
+
+	p.value, p.constructed = value, true
+	return p.value, nil
+}
+
+// withServiceScope runs the call with a fresh service scope and closes it once the call (or the stream it returns) is done
+func (r *Robin) withServiceScope(ctx *Context, call func() (any, error)) (any, error) {
+	scope := r.services.newScope(ctx)
+
+	closeScope := true
+	defer func() {
+		if closeScope {
+			scope.close()
+		}
+	}()
+
+	result, err := call()
+	if stream, ok := result.(types.Stream); ok && err == nil {
+		closeScope = false
+
+		return types.Stream(func(emit func(any) error) error {
+			defer scope.close()
+			return stream(emit)
+		}), nil
+	}
+
+	return result, err
+}
@@ -0,0 +1,81 @@
+package robin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+type (
+	serviceConfig struct{ Name string }
+	serviceCache  struct{ ID int }
+	serviceTx     struct{ ID int }
+)
+
+func Test_Services(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	var cacheConstructed, txConstructed, txCleanedUp int
+
+	robin.Provide(r, &serviceConfig{Name: "robin"})
+	robin.ProvideLazy(r, func() (*serviceCache, error) {
+		cacheConstructed++
+		return &serviceCache{ID: cacheConstructed}, nil
+	})
+	robin.ProvideScoped(r, func(ctx *robin.Context) (*serviceTx, func(), error) {
+		txConstructed++
+		return &serviceTx{ID: txConstructed}, func() { txCleanedUp++ }, nil
+	})
+
+	instance, err := r.
+		Add(robin.Query("info", func(ctx *robin.Context, _ robin.Void) (map[string]any, error) {
+			tx := robin.Service[*serviceTx](ctx)
+			if robin.Service[*serviceTx](ctx) != tx {
+				t.Errorf("expected the same scoped service within a call")
+			}
+
+			if txCleanedUp != txConstructed-1 {
+				t.Errorf("expected the scoped service not to be cleaned up before the procedure is done")
+			}
+
+			if _, err := robin.LookupService[*strings.Builder](ctx); err == nil {
+				t.Errorf("expected an error for a service that has not been provided")
+			}
+
+			return map[string]any{
+				"config": robin.Service[*serviceConfig](ctx).Name,
+				"cache":  robin.Service[*serviceCache](ctx).ID,
+				"tx":     tx.ID,
+			}, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=q__info", strings.NewReader(""))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		expected := `{"data":{"cache":1,"config":"robin","tx":` + strconv.Itoa(i) + `},"ok":true}`
+		if rec.Body.String() != expected {
+			t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+		}
+	}
+
+	if cacheConstructed != 1 {
+		t.Errorf("expected the lazy service to be constructed once, got %d", cacheConstructed)
+	}
+
+	if txConstructed != 2 || txCleanedUp != 2 {
+		t.Errorf("expected the scoped service to be constructed and cleaned up once per call, got %d and %d", txConstructed, txCleanedUp)
+	}
+}
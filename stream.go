@@ -0,0 +1,157 @@
+package robin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"go.trulyao.dev/robin/types"
+)
+
+// ContentTypeNDJSON is the content type of responses from procedures that stream their result
+const ContentTypeNDJSON = "application/x-ndjson"
+
+// flushWriter writes chunks to the response and flushes them immediately so that the client receives them as they are produced
+type flushWriter struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	debug      bool
+}
+
+func newFlushWriter(w http.ResponseWriter, debug bool) *flushWriter {
+	return &flushWriter{w: w, controller: http.NewResponseController(w), debug: debug}
+}
+
+func (f *flushWriter) write(chunk []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.w.Write(chunk); err != nil {
+		return err
+	}
+
+	f.flush()
+	return nil
+}
+
+func (f *flushWriter) flush() {
+	if err := f.controller.Flush(); err != nil && f.debug {
+		slog.Warn("Failed to flush response", slog.String("error", err.Error()))
+	}
+}
+
+// handleSubscription handles a subscription call, the resulting stream is served as Server-Sent Events until the subscription ends or the client goes away
+//
+// Each value is sent as a `data` event, an error that occurs after the stream has started is sent as an `error` event and the stream is always terminated with an `end` event
+func (r *Robin) handleSubscription(ctx *Context, procedure Procedure) error {
+	result, err := r.callProcedure(ctx, procedure)
+	if err != nil {
+		return err
+	}
+
+	stream, ok := result.(types.Stream)
+	if !ok {
+		return RobinError{Reason: fmt.Sprintf("Procedure %s did not return a stream", procedure.Name())}
+	}
+
+	w := newFlushWriter(ctx.Response(), r.debug)
+
+	writeEvent := func(event string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return RobinError{Reason: "Failed to marshal event", OriginalError: err}
+		}
+
+		return w.write(fmt.Appendf(nil, "event: %s\ndata: %s\n\n", event, payload))
+	}
+
+	ctx.Response().Header().Set("Content-Type", "text/event-stream")
+	ctx.Response().Header().Set("Cache-Control", "no-cache")
+	ctx.Response().Header().Set("Connection", "keep-alive")
+	ctx.Response().WriteHeader(http.StatusOK)
+	w.flush()
+
+	err = stream(func(value any) error {
+		// Stop producing values as soon as the client goes away
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return writeEvent("data", value)
+	})
+
+	// The headers have already been sent at this point, so the error has to be delivered as an event instead
+	if err != nil && ctx.Err() == nil {
+		if r.debug {
+			slog.Error("An error occurred in subscription", slog.Any("error", err))
+		}
+
+		errorResponse, _ := r.handleError(ctx, err)
+		if err := writeEvent("error", errorResponse); err != nil {
+			slog.Error("Failed to write error event", slog.String("error", err.Error()))
+		}
+	}
+
+	if ctx.Err() == nil {
+		if err := writeEvent("end", nil); err != nil {
+			slog.Error("Failed to write end event", slog.String("error", err.Error()))
+		}
+	}
+
+	return nil
+}
+
+// writeNDJSONStream writes the values produced by a query or mutation that returns a channel or an iterator as newline-delimited JSON
+//
+// Every value is written as `{"d": value}` on its own line, and the stream is always terminated with an envelope line; `{"ok": true}` if the stream completed or `{"ok": false, "error": ...}` if an error occurred mid-stream
+func (r *Robin) writeNDJSONStream(ctx *Context, stream types.Stream) error {
+	w := newFlushWriter(ctx.Response(), r.debug)
+
+	writeLine := func(line any) error {
+		payload, err := json.Marshal(line)
+		if err != nil {
+			return RobinError{Reason: "Failed to marshal stream item", OriginalError: err}
+		}
+
+		return w.write(append(payload, '\n'))
+	}
+
+	ctx.Response().Header().Set("Content-Type", ContentTypeNDJSON)
+	ctx.Response().Header().Set("Cache-Control", "no-cache")
+	ctx.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Response().WriteHeader(http.StatusOK)
+	w.flush()
+
+	err := stream(func(value any) error {
+		// Stop producing values as soon as the client goes away
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return writeLine(map[string]any{"d": value})
+	})
+
+	// Nobody is listening anymore
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	envelope := map[string]any{"ok": true}
+	if err != nil {
+		if r.debug {
+			slog.Error("An error occurred while streaming result", slog.Any("error", err))
+		}
+
+		errorResponse, _ := r.handleError(ctx, err)
+		envelope = map[string]any{"ok": false, "error": errorResponse}
+	}
+
+	if err := writeLine(envelope); err != nil {
+		slog.Error("Failed to write stream envelope", slog.String("error", err.Error()))
+	}
+
+	return nil
+}
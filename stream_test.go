@@ -0,0 +1,101 @@
+package robin_test
+
+import (
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_StreamingResults(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("range", func(ctx *robin.Context, n int) (iter.Seq[int], error) {
+			return func(yield func(int) bool) {
+				for i := 1; i <= n; i++ {
+					if !yield(i) {
+						return
+					}
+				}
+			}, nil
+		})).
+		Add(robin.Query("letters", func(ctx *robin.Context, _ robin.Void) (<-chan string, error) {
+			ch := make(chan string, 2)
+			ch <- "a"
+			ch <- "b"
+			close(ch)
+			return ch, nil
+		})).
+		Add(robin.Mutation("import", func(ctx *robin.Context, _ robin.Void) (iter.Seq2[int, error], error) {
+			return func(yield func(int, error) bool) {
+				if !yield(1, nil) {
+					return
+				}
+
+				yield(0, errors.New("import failed"))
+			}, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		expected string
+	}{
+		{
+			name:     "streams an iterator",
+			url:      "/?__proc=q__range",
+			body:     `{"d": 2}`,
+			expected: `{"d":1}` + "\n" + `{"d":2}` + "\n" + `{"ok":true}` + "\n",
+		},
+		{
+			name:     "streams a channel",
+			url:      "/?__proc=q__letters",
+			expected: `{"d":"a"}` + "\n" + `{"d":"b"}` + "\n" + `{"ok":true}` + "\n",
+		},
+		{
+			name:     "terminates with an error envelope",
+			url:      "/?__proc=m__import",
+			expected: `{"d":1}` + "\n" + `{"error":"import failed","ok":false}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if contentType := rec.Header().Get("Content-Type"); contentType != robin.ContentTypeNDJSON {
+				t.Errorf("expected content type %s, got %s", robin.ContentTypeNDJSON, contentType)
+			}
+
+			if rec.Body.String() != tt.expected {
+				t.Errorf("expected body %q, got %q", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("rejects streaming procedures in a batch", func(t *testing.T) {
+		body := `{"d": [{"type": "query", "name": "range", "payload": 2}]}`
+		req := httptest.NewRequest(http.MethodPost, "/?"+robin.BatchKey+"=1", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if !strings.Contains(rec.Body.String(), `"ok":false`) || !strings.Contains(rec.Body.String(), "cannot be batched") {
+			t.Errorf("expected the call to be rejected, got %s", rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package robin
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"go.trulyao.dev/robin/internal/guarded"
+	"go.trulyao.dev/robin/types"
+)
+
+type subscription[ReturnType any, ParamsType any] struct {
+	*baseProcedure[ReturnType, ParamsType]
+
+	// The function that will be called when a client subscribes
+	subscribeFn SubscriptionFn[ReturnType, ParamsType]
+}
+
+// Creates a new subscription with the given name and handler function, the return type is the type of each value pushed to the subscriber
+func Subscription[R any, B any](name string, fn SubscriptionFn[R, B]) *subscription[R, B] {
+	var body B
+	expectedPayloadType := guarded.ExpectsPayload(body)
+
+	s := &subscription[R, B]{
+		baseProcedure: &baseProcedure[R, B]{
+			name:                name,
+			expectedPayloadType: expectedPayloadType,
+			excludedMiddleware:  &types.ExclusionList{},
+		},
+		subscribeFn: fn,
+	}
+	s.alias = s.NormalizeProcedureName()
+
+	return s
+}
+
+// Alias for `Subscription` to create a new subscription procedure
+func S[R any, B any](name string, fn SubscriptionFn[R, B]) *subscription[R, B] {
+	return Subscription(name, fn)
+}
+
+// Creates a new subscription with the given name, handler function and middleware functions
+func SubscriptionWithMiddleware[R any, B any](
+	name string,
+	fn SubscriptionFn[R, B],
+	middleware ...types.Middleware,
+) *subscription[R, B] {
+	s := Subscription(name, fn)
+	s.WithMiddleware(middleware...)
+	return s
+}
+
+// Creates a new subscription from a function that returns a channel, every value received from the channel is pushed to the subscriber until the channel is closed or the subscriber goes away
+//
+// NOTE: the function should stop sending (and close the channel) once the context is done, otherwise its goroutine will block forever
+func SubscriptionFromChannel[R any, B any](name string, fn func(ctx *Context, body B) (<-chan R, error)) *subscription[R, B] {
+	return Subscription(name, func(ctx *Context, body B, sink *Sink[R]) error {
+		ch, err := fn(ctx, body)
+		if err != nil {
+			return err
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case value, ok := <-ch:
+				if !ok {
+					return nil
+				}
+
+				if err := sink.Send(value); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// Creates a new subscription from a function that returns an iterator, every value produced by the iterator is pushed to the subscriber until it is exhausted or the subscriber goes away
+func SubscriptionFromSeq[R any, B any](name string, fn func(ctx *Context, body B) (iter.Seq[R], error)) *subscription[R, B] {
+	return Subscription(name, func(ctx *Context, body B, sink *Sink[R]) error {
+		seq, err := fn(ctx, body)
+		if err != nil {
+			return err
+		}
+
+		for value := range seq {
+			if err := sink.Send(value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Returns the type of the procedure, one of 'query', 'mutation' or 'subscription' - in this case, it's always 'subscription'
+func (s *subscription[_, _]) Type() ProcedureType {
+	return ProcedureTypeSubscription
+}
+
+// String returns a string representation of the subscription
+func (s *subscription[_, _]) String() string {
+	return fmt.Sprintf("Subscription(%s)", s.name)
+}
+
+// NormalizeProcedureName normalizes the procedure name to a more human-readable format for use in the REST API
+func (s *subscription[_, _]) NormalizeProcedureName() string {
+	var alias string
+
+	// Replace all non-alphanumeric characters with dot
+	alias = ReAlphaNumeric.ReplaceAllString(s.name, ".")
+
+	// Replace all multiple dots with a single dot
+	alias = ReIllegalDot.ReplaceAllString(alias, ".")
+
+	// Remove all words that are associable with the subscription type
+	alias = ReSubscriptionWords.ReplaceAllString(alias, "")
+
+	// Remove all leading and trailing dots and spaces
+	alias = strings.TrimSpace(alias)
+	alias = strings.Trim(alias, ".")
+
+	return alias
+}
+
+// WithTimeout sets the maximum duration the subscription (including its middleware) is allowed to stay open for
+//
+// NOTE: the default timeout never applies to subscriptions, so this is the only way to bound them
+func (s *subscription[_, _]) WithTimeout(timeout time.Duration) Procedure {
+	s.timeout = timeout
+	return s
+}
+
+// WithAlias sets the alias of the subscription
+func (s *subscription[_, _]) WithAlias(alias string) Procedure {
+	s.alias = alias
+	return s
+}
+
+// Calls the subscription with the given context and params, the returned stream has to be driven by the transport to produce values
+func (s *subscription[ReturnType, ParamsType]) Call(ctx *Context, rawParams any) (any, error) {
+	params, err := s.castPayload(rawParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.subscribeFn == nil {
+		return nil, RobinError{Reason: fmt.Sprintf("Procedure %s has no function attached", s.name)}
+	}
+
+	return types.Stream(func(emit func(any) error) error {
+		return s.subscribeFn(ctx, params, &Sink[ReturnType]{emit: emit})
+	}), nil
+}
+
+// IsStreaming returns whether the procedure streams its result or not, subscriptions always do
+func (s *subscription[_, _]) IsStreaming() bool {
+	return true
+}
+
+// Validate validates the subscription
+func (s *subscription[_, _]) Validate() error {
+	// Check if the subscription name is valid
+	if s.name == "" {
+		return RobinError{Reason: "Subscription name cannot be empty"}
+	}
+
+	if !ReValidProcedureName.MatchString(s.name) {
+		return RobinError{
+			Reason: fmt.Sprintf(
+				"Invalid procedure name: `%s`, expected string matching regex `%s` (example: `get_user`, `todo.create`)",
+				s.name,
+				ReValidProcedureName,
+			),
+		}
+	}
+
+	if err := s.validateDeclaredErrors(); err != nil {
+		return err
+	}
+
+	return s.validatePayloadRules()
+}
+
+// MiddlewareFns returns the middleware functions to be executed before the subscription is started
+func (s *subscription[_, _]) MiddlewareFns() []types.Middleware {
+	return s.middlewareFns
+}
+
+// PrependMiddleware sets the middleware functions for the subscription at the beginning of the middleware chain
+func (s *subscription[_, _]) PrependMiddleware(fns ...types.Middleware) Procedure {
+	s.middlewareFns = append(fns, s.middlewareFns...)
+	return s
+}
+
+// WithMiddleware sets the middleware functions for the subscription
+func (s *subscription[_, _]) WithMiddleware(fns ...types.Middleware) Procedure {
+	s.middlewareFns = append(s.middlewareFns, fns...)
+	return s
+}
+
+// PrependAroundMiddleware sets around middleware for the subscription at the beginning of the chain
+func (s *subscription[_, _]) PrependAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	s.aroundMiddlewareFns = append(fns, s.aroundMiddlewareFns...)
+	return s
+}
+
+// WithAroundMiddleware adds middleware that wrap the subscription call, they run before the regular middleware functions and can act on the result
+func (s *subscription[_, _]) WithAroundMiddleware(fns ...types.AroundMiddleware) Procedure {
+	s.aroundMiddlewareFns = append(s.aroundMiddlewareFns, fns...)
+	return s
+}
+
+// WithGuard adds guards that are executed after the payload has been decoded and validated, right before the subscription is called
+func (s *subscription[_, _]) WithGuard(guards ...types.Guard) Procedure {
+	s.guards = append(s.guards, guards...)
+	return s
+}
+
+// WithTypedGuard adds a guard that receives the payload as the type the subscription expects
+func (s *subscription[ReturnType, ParamsType]) WithTypedGuard(fn func(ctx *Context, payload ParamsType) error) *subscription[ReturnType, ParamsType] {
+	s.guards = append(s.guards, typedGuard(fn))
+	return s
+}
+
+// RequireKeys declares the keys that the subscription needs its middleware to set, building the instance fails if none of them do
+func (s *subscription[_, _]) RequireKeys(keys ...types.ContextKey) Procedure {
+	s.requiredKeys = append(s.requiredKeys, keys...)
+	return s
+}
+
+// ProvideKeys declares the keys that the subscription's own middleware set
+func (s *subscription[_, _]) ProvideKeys(keys ...types.ContextKey) Procedure {
+	s.providedKeys = append(s.providedKeys, keys...)
+	return s
+}
+
+// Errors declares the errors the subscription can return, see `types.CodedError`
+func (s *subscription[_, _]) Errors(errs ...types.CodedError) Procedure {
+	s.declaredErrors = append(s.declaredErrors, errs...)
+	return s
+}
+
+// WithErrorHandler sets an error handler for the subscription's errors that takes precedence over the global one
+func (s *subscription[_, _]) WithErrorHandler(handler types.ContextErrorHandler) Procedure {
+	s.errorHandler = handler
+	return s
+}
+
+// ExcludeMiddleware takes a list of global middleware names and excludes them from the subscription
+func (s *subscription[_, _]) ExcludeMiddleware(names ...string) types.Procedure {
+	s.excludedMiddleware.AddMany(names)
+	return s
+}
+
+// ExcludedMiddleware returns the list of middleware functions that are excluded from the subscription
+func (s *subscription[_, _]) ExcludedMiddleware() *types.ExclusionList {
+	return s.excludedMiddleware
+}
+
+// WARNING: This is an experimental feature and may be removed in the future in favour of a more robust solution and without notice
+//
+// See `Query.WithRawPayload` for more details, your subscription must have the following signature:
+//
+// func (ctx *Context, payload io.ReadCloser, sink *Sink[YourEventType]) error
+func (s *subscription[ReturnType, ParamsType]) WithRawPayload(actualPayloadType any) Procedure {
+	// The payload type of a subscription function is the same as that of a regular procedure function, so we can reuse the check
+	mustImplementReadCloser(ProcedureFn[ReturnType, ParamsType](nil), ProcedureTypeSubscription)
+
+	s.in.SetOverrideType(actualPayloadType)
+	s.expectedPayloadType = types.ExpectedPayloadRaw
+	return s
+}
+
+var _ Procedure = (*subscription[any, any])(nil)
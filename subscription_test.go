@@ -0,0 +1,187 @@
+package robin_test
+
+import (
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_SubscriptionAlias(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		alias       string
+		expected    string
+	}{
+		{"matching name without any alias", "watch_todos", "", "todos"},
+		{"NOT matching name without any alias", "todos_changes", "", "todos.changes"},
+		{"matching name with alias", "watch_todos", "todo-feed", "todo-feed"},
+		{"matching name with [on] prefix", "on.todos", "", "todos"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			s := robin.S(test.name, func(ctx *robin.Context, _ robin.Void, sink *robin.Sink[string]) error {
+				return nil
+			})
+
+			if test.alias != "" {
+				s.WithAlias(test.alias)
+			}
+
+			if alias := s.Alias(); alias != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, alias)
+			}
+		})
+	}
+}
+
+func Test_SubscriptionServerSentEvents(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Subscription("count", func(ctx *robin.Context, n int, sink *robin.Sink[int]) error {
+			for i := 1; i <= n; i++ {
+				if err := sink.Send(i); err != nil {
+					return err
+				}
+			}
+
+			return errors.New("done counting")
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/?__proc=s__count", strings.NewReader(`{"d": 2}`))
+	rec := httptest.NewRecorder()
+	instance.Handler()(rec, req)
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("expected content type text/event-stream, got %s", contentType)
+	}
+
+	expected := "event: data\ndata: 1\n\n" +
+		"event: data\ndata: 2\n\n" +
+		"event: error\ndata: \"done counting\"\n\n" +
+		"event: end\ndata: null\n\n"
+
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("expected body %q, got %q", expected, body)
+	}
+}
+
+func Test_SubscriptionRestEndpoint(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Subscription("count", func(ctx *robin.Context, n int, sink *robin.Sink[int]) error {
+			for i := 1; i <= n; i++ {
+				if err := sink.Send(i); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	instance.AttachRestEndpoints(mux, &robin.RestApiOptions{Enable: true})
+
+	// `EventSource` can only send GET requests without a body, so the payload has to come from the URL
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/count?"+robin.PayloadKey+"=2", nil))
+
+	expected := "event: data\ndata: 1\n\n" +
+		"event: data\ndata: 2\n\n" +
+		"event: end\ndata: null\n\n"
+
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("expected body %q, got %q", expected, body)
+	}
+}
+
+func Test_SubscriptionFromChannelAndSeq(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.SubscriptionFromChannel("count.channel", func(ctx *robin.Context, n int) (<-chan int, error) {
+			ch := make(chan int)
+			go func() {
+				defer close(ch)
+				for i := 1; i <= n; i++ {
+					select {
+					case ch <- i:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			return ch, nil
+		})).
+		Add(robin.SubscriptionFromSeq("count.seq", func(ctx *robin.Context, n int) (iter.Seq[int], error) {
+			if n < 0 {
+				return nil, errors.New("expected a positive number")
+			}
+
+			return func(yield func(int) bool) {
+				for i := 1; i <= n; i++ {
+					if !yield(i) {
+						return
+					}
+				}
+			}, nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		procedure string
+		payload   string
+		expected  string
+	}{
+		{"count.channel", "2", "event: data\ndata: 1\n\nevent: data\ndata: 2\n\nevent: end\ndata: null\n\n"},
+		{"count.seq", "2", "event: data\ndata: 1\n\nevent: data\ndata: 2\n\nevent: end\ndata: null\n\n"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/?__proc=s__"+test.procedure, strings.NewReader(`{"d": `+test.payload+`}`))
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, req)
+
+		if body := rec.Body.String(); body != test.expected {
+			t.Errorf("%s: expected body %q, got %q", test.procedure, test.expected, body)
+		}
+	}
+
+	// An error returned before the iterator is produced is reported like any other error
+	req := httptest.NewRequest(http.MethodPost, "/?__proc=s__count.seq", strings.NewReader(`{"d": -1}`))
+	rec := httptest.NewRecorder()
+	instance.Handler()(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "expected a positive number") {
+		t.Errorf("expected the error to be reported, got %q", rec.Body.String())
+	}
+}
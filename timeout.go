@@ -0,0 +1,222 @@
+package robin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.trulyao.dev/robin/types"
+)
+
+// TimeoutHeader is the header clients can use to request a shorter deadline (in milliseconds) for a call, this can never extend the configured timeout
+const TimeoutHeader = "X-Robin-Timeout"
+
+type (
+	// The outcome of a procedure running in its own goroutine
+	timeoutOutcome struct {
+		result   any
+		err      error
+		panicked any
+	}
+
+	// timeoutResponseWriter holds on to everything the procedure writes to the response, so that nothing it does after the timeout can race with (or corrupt) the timeout error response
+	timeoutResponseWriter struct {
+		mu          sync.Mutex
+		w           http.ResponseWriter
+		header      http.Header
+		timedOut    bool
+		wroteHeader bool
+	}
+)
+
+// procedureTimeout returns the effective timeout for the call, zero means the call is not bounded
+func (r *Robin) procedureTimeout(ctx *Context, procedure Procedure) time.Duration {
+	timeout := procedure.Timeout()
+	if timeout == 0 && procedure.Type() != ProcedureTypeSubscription {
+		timeout = r.defaultTimeout
+	}
+
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	// Clients can only ask for a shorter deadline, the header is ignored if it is invalid
+	if requested, err := strconv.ParseInt(ctx.Header(TimeoutHeader), 10, 64); err == nil && requested > 0 {
+		requestedTimeout := time.Duration(requested) * time.Millisecond
+		if timeout == 0 || requestedTimeout < timeout {
+			timeout = requestedTimeout
+		}
+	}
+
+	return timeout
+}
+
+// runProcedureWithTimeout runs the procedure in its own goroutine and gives up on it once the timeout is exceeded, the procedure's context is cancelled so that it can stop whatever it is doing
+//
+// NOTE: for procedures that stream their result, the timeout covers the entire stream
+func (r *Robin) runProcedureWithTimeout(ctx *Context, procedure Procedure, timeout time.Duration) (any, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx.Context(), timeout)
+
+	// The procedure gets its own copy of the context since it may keep running (and using it) after we have given up on it
+	fork := ctx.Fork()
+	fork.SetContext(timeoutCtx)
+
+	originalResponse := ctx.Response()
+	w := &timeoutResponseWriter{w: originalResponse, header: make(http.Header)}
+	fork.SetResponse(w)
+
+	done := make(chan timeoutOutcome, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				// The stack has to be captured here since it is gone once the panic is re-raised in the calling goroutine
+				done <- timeoutOutcome{panicked: capturePanic(e)}
+			}
+		}()
+
+		result, err := r.runProcedure(fork, procedure)
+		done <- timeoutOutcome{result: result, err: err}
+	}()
+
+	select {
+	case outcome := <-done:
+		// The procedure is done with its context at this point, so we take over whatever it changed (e.g. state set by middleware) and hand the original response back
+		w.release()
+		ctx.Adopt(fork)
+		ctx.SetResponse(originalResponse)
+
+		if outcome.panicked != nil {
+			cancel()
+			// Re-panic in the calling goroutine so that the panic is handled (or not) like any other
+			panic(outcome.panicked)
+		}
+
+		if outcome.err != nil {
+			cancel()
+			if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && errors.Is(outcome.err, context.DeadlineExceeded) {
+				return nil, makeTimeoutError(procedure, timeout)
+			}
+
+			return nil, outcome.err
+		}
+
+		// The stream is driven after we return, so the context can only be cancelled once it is done
+		if stream, ok := outcome.result.(types.Stream); ok {
+			return types.Stream(func(emit func(any) error) error {
+				defer cancel()
+
+				err := stream(emit)
+				if err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+					return makeTimeoutError(procedure, timeout)
+				}
+
+				return err
+			}), nil
+		}
+
+		cancel()
+		return outcome.result, nil
+
+	case <-timeoutCtx.Done():
+		w.timeout()
+		cancel()
+
+		// The procedure keeps running until it notices the cancellation, a panic can no longer be re-raised here by then so it is reported instead of being dropped
+		go func() {
+			outcome := <-done
+			if outcome.panicked == nil {
+				return
+			}
+
+			if r.trapPanic {
+				_ = r.makePanicError(fork, outcome.panicked)
+				return
+			}
+
+			// The panic handler is only for trapped panics, so this is just logged like net/http would have done for an untrapped one
+			captured := capturePanic(outcome.panicked)
+			slog.Error(
+				"Procedure panicked after timing out",
+				slog.Any("panic", captured.value),
+				slog.String("procedureName", fork.ProcedureName()),
+				slog.String("stack", string(captured.stack)),
+			)
+		}()
+
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+			return nil, makeTimeoutError(procedure, timeout)
+		}
+
+		// The client went away
+		return nil, timeoutCtx.Err()
+	}
+}
+
+func makeTimeoutError(procedure Procedure, timeout time.Duration) error {
+	return types.Error{
+		Message: fmt.Sprintf("Procedure `%s` timed out after %s", procedure.Name(), timeout),
+		Code:    http.StatusGatewayTimeout,
+	}
+}
+
+func (t *timeoutResponseWriter) Header() http.Header {
+	return t.header
+}
+
+func (t *timeoutResponseWriter) WriteHeader(statusCode int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.writeHeader(statusCode)
+}
+
+func (t *timeoutResponseWriter) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	t.writeHeader(http.StatusOK)
+	return t.w.Write(b)
+}
+
+// Unwrap allows `http.ResponseController` to reach the underlying response writer
+func (t *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return t.w
+}
+
+func (t *timeoutResponseWriter) writeHeader(statusCode int) {
+	if t.timedOut || t.wroteHeader {
+		return
+	}
+
+	t.wroteHeader = true
+	maps.Copy(t.w.Header(), t.header)
+	t.w.WriteHeader(statusCode)
+}
+
+// release copies the headers set by the procedure to the underlying response writer if they haven't been written yet
+func (t *timeoutResponseWriter) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.wroteHeader {
+		maps.Copy(t.w.Header(), t.header)
+	}
+}
+
+// timeout discards everything the procedure writes from now on
+func (t *timeoutResponseWriter) timeout() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.timedOut = true
+}
@@ -0,0 +1,139 @@
+package robin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_ProcedureTimeout(t *testing.T) {
+	r, err := robin.New(robin.Options{DefaultTimeout: time.Second, TrapPanic: true})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	sleep := func(ctx *robin.Context, ms int) (string, error) {
+		select {
+		case <-time.After(time.Duration(ms) * time.Millisecond):
+			ctx.SetHeader("X-Slept", "true")
+			return "done", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	instance, err := r.
+		Add(robin.Query("sleep", sleep)).
+		Add(robin.Query("bounded_sleep", sleep).WithTimeout(20 * time.Millisecond)).
+		Add(robin.Query("stubborn_sleep", func(ctx *robin.Context, ms int) (string, error) {
+			// Ignores the context entirely, the call should still be bounded
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return "done", nil
+		}).WithTimeout(20 * time.Millisecond)).
+		Add(robin.Query("bounded_panic", func(ctx *robin.Context, ms int) (string, error) {
+			panic("something broke")
+		}).WithTimeout(20 * time.Millisecond)).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		proc        string
+		payload     string
+		header      string
+		status      int
+	}{
+		{"completes within the default timeout", "sleep", "5", "", http.StatusOK},
+		{"exceeds the procedure timeout", "bounded_sleep", "200", "", http.StatusGatewayTimeout},
+		{"exceeds the procedure timeout without checking the context", "stubborn_sleep", "200", "", http.StatusGatewayTimeout},
+		{"exceeds the deadline requested by the client", "sleep", "200", "20", http.StatusGatewayTimeout},
+		{"cannot extend the configured timeout", "bounded_sleep", "200", "5000", http.StatusGatewayTimeout},
+		{"panics within the procedure timeout", "bounded_panic", "0", "", http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/?__proc=q__"+test.proc, strings.NewReader(`{"d":`+test.payload+`}`))
+
+			if test.header != "" {
+				req.Header.Set(robin.TimeoutHeader, test.header)
+			}
+
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if test.status == http.StatusOK && rec.Header().Get("X-Slept") != "true" {
+				t.Errorf("expected headers set by the procedure to be sent")
+			}
+		})
+	}
+}
+
+func Test_ProcedureTimeoutLatePanic(t *testing.T) {
+	for _, trapPanic := range []bool{true, false} {
+		panics := make(chan any, 1)
+
+		r, err := robin.New(robin.Options{
+			TrapPanic: trapPanic,
+			PanicHandler: func(ctx *robin.Context, recovered any, stack []byte) {
+				panics <- recovered
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create robin instance: %v", err)
+		}
+
+		instance, err := r.
+			Add(robin.Query("late_panic", func(ctx *robin.Context, _ robin.Void) (string, error) {
+				time.Sleep(50 * time.Millisecond)
+
+				// The call has timed out by now, but the procedure can still use its context
+				ctx.Set("slept", true)
+				ctx.SetHeader("X-Slept", "true")
+				panic("something broke late")
+			}).WithTimeout(10 * time.Millisecond)).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build robin instance: %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		instance.Handler()(rec, httptest.NewRequest(http.MethodPost, "/?__proc=q__late_panic", strings.NewReader("")))
+
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("[trapPanic=%t] expected status %d, got %d (%s)", trapPanic, http.StatusGatewayTimeout, rec.Code, rec.Body.String())
+		}
+
+		if trapPanic {
+			select {
+			case recovered := <-panics:
+				if recovered != "something broke late" {
+					t.Errorf("expected the late panic to be reported, got %v", recovered)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected the late panic to be reported to the panic handler")
+			}
+		} else {
+			// The panic handler is only for trapped panics
+			select {
+			case recovered := <-panics:
+				t.Errorf("expected the panic handler not to be called without `TrapPanic`, got %v", recovered)
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+
+		if rec.Header().Get("X-Slept") != "" {
+			t.Errorf("[trapPanic=%t] expected headers set after the timeout to be discarded", trapPanic)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package types
 
 import (
+	"context"
+	"crypto/x509"
 	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // A container for user-defined state
@@ -39,12 +42,51 @@ func (s *State) Get(key string) any {
 	return s.m[key]
 }
 
+// Copy returns a new state container with a shallow copy of the values in this one
+func (s *State) Copy() State {
+	if s.useMutex {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	m := make(map[string]any, len(s.m))
+	for key, value := range s.m {
+		m[key] = value
+	}
+
+	return State{m: m, useMutex: s.useMutex}
+}
+
+// replace swaps the values in the state container for the given ones
+func (s *State) replace(m map[string]any) {
+	if s.useMutex {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	s.m = m
+}
+
 // UseMutex sets whether to use the mutex lock on the state container
 func (s *State) UseMutex(useMutex bool) {
 	s.useMutex = useMutex
 }
 
+// ContextKey is implemented by typed keys (see `robin.NewKey`), procedures and middleware use it to declare the values they need and provide
+type ContextKey interface {
+	Name() string
+}
+
+// selfContextKey is used to detect contexts derived from the robin context itself
+type selfContextKey struct{}
+
+// Context carries everything about a single procedure call, it also implements `context.Context` so it can be passed directly to anything that expects one (e.g. database calls)
+//
+// The underlying context is derived from the request's context, so it is cancelled when the client goes away
 type Context struct {
+	// The underlying context, this is initially the request's context
+	ctx context.Context
+
 	// The raw incoming request
 	request *http.Request
 
@@ -60,18 +102,115 @@ type Context struct {
 	// User-defined state - this can be used to store any data that needs to be shared across procedures
 	// For example, database connections, etc.
 	//
-	// NOTE: this is shared across all a functions in a single request
+	// NOTE: this is shared across all a functions in a single request, prefer typed keys (see `robin.NewKey`) for values that middleware pass to procedures
 	State State
 }
 
 func NewContext(req *http.Request, res *http.ResponseWriter) *Context {
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
+
 	return &Context{
+		ctx:      ctx,
 		request:  req,
 		response: res,
 		State:    NewState(),
 	}
 }
 
+// Deadline returns the time when the call should be cancelled, if any
+func (c *Context) Deadline() (time.Time, bool) {
+	return c.ctx.Deadline()
+}
+
+// Done returns a channel that is closed when the call is cancelled (e.g. the client goes away)
+func (c *Context) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err returns the reason the call was cancelled, if it has been
+func (c *Context) Err() error {
+	return c.ctx.Err()
+}
+
+// Value returns the value associated with the key in the underlying context, string keys fall back to the state container if they are not found there
+func (c *Context) Value(key any) any {
+	if _, ok := key.(selfContextKey); ok {
+		return c
+	}
+
+	if value := c.ctx.Value(key); value != nil {
+		return value
+	}
+
+	if key, ok := key.(string); ok {
+		return c.State.Get(key)
+	}
+
+	return nil
+}
+
+// WithValue attaches a value to the underlying context, it is visible to everything that runs after it for the current call (e.g. the next middleware functions and the procedure)
+//
+// NOTE: unlike `context.WithValue`, this modifies the context in place since middleware functions cannot return a new context
+func (c *Context) WithValue(key, value any) {
+	c.SetContext(context.WithValue(c.ctx, key, value))
+}
+
+// Context returns the underlying context, new contexts passed to `SetContext` must be derived from this
+func (c *Context) Context() context.Context {
+	return c.ctx
+}
+
+// SetContext replaces the underlying context, this is useful for deriving a context with a deadline or cancellation in middleware functions
+//
+// WARNING: the new context must be derived from `Context()` and NOT the robin context itself, that would create a cycle and this will panic
+func (c *Context) SetContext(ctx context.Context) {
+	if ctx == nil {
+		panic("robin: nil context")
+	}
+
+	if ctx.Value(selfContextKey{}) == c {
+		panic("robin: the new context must be derived from `ctx.Context()`, not the robin context itself")
+	}
+
+	c.ctx = ctx
+	if c.request != nil {
+		// Keep the request's context in sync for code that still uses `Request().Context()`
+		c.request = c.request.WithContext(ctx)
+	}
+}
+
+// Fork returns a copy of the context for code that runs in another goroutine (e.g. a procedure bounded by a timeout), changes made to either of them afterwards are not visible to the other
+//
+// NOTE: the state container is copied too, use `Adopt` to take over the changes once the goroutine is done
+func (c *Context) Fork() *Context {
+	return &Context{
+		ctx:           c.ctx,
+		request:       c.request,
+		response:      c.response,
+		procedureName: c.procedureName,
+		procedureType: c.procedureType,
+		State:         c.State.Copy(),
+	}
+}
+
+// Adopt takes over everything a forked context has changed, including its state
+//
+// WARNING: this must only be called once nothing else is using the forked context
+func (c *Context) Adopt(fork *Context) {
+	c.ctx = fork.ctx
+	c.request = fork.request
+	c.response = fork.response
+	c.procedureName = fork.procedureName
+	c.procedureType = fork.procedureType
+	// The forked context may still be used by a stream that is driven later, so the values are copied rather than shared
+	state := fork.State.Copy()
+	c.State.replace(state.m)
+}
+
 // Request returns the underlying request
 func (c *Context) Request() *http.Request {
 	return c.request
@@ -82,6 +221,11 @@ func (c *Context) Response() http.ResponseWriter {
 	return *c.response
 }
 
+// SetResponse replaces the underlying response writer
+func (c *Context) SetResponse(w http.ResponseWriter) {
+	c.response = &w
+}
+
 // Cookie returns the cookie with the specified key from the request and a boolean indicating whether the cookie exists
 func (c *Context) Cookie(key string) (*http.Cookie, bool) {
 	cookie, err := c.request.Cookie(key)
@@ -147,6 +291,15 @@ func (c *Context) Query(key string) string {
 	return c.request.URL.Query().Get(key)
 }
 
+// ClientCertificate returns the certificate the client presented over mutual TLS, this is nil if the connection is not over TLS or the certificate was not verified
+func (c *Context) ClientCertificate() *x509.Certificate {
+	if c.request == nil || c.request.TLS == nil || len(c.request.TLS.VerifiedChains) == 0 || len(c.request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return c.request.TLS.VerifiedChains[0][0]
+}
+
 // GetBody returns the body of the request as a byte slice
 func (c *Context) GetBody() []byte {
 	body, _ := io.ReadAll(c.request.Body)
@@ -1,6 +1,35 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type (
+	// A type that can be marshalled to JSON or simply a string
+	Serializable interface {
+		json.Marshaler
+	}
+
+	// ContextErrorHandler converts an error into a response like `ErrorHandler` does, but with access to the context of the call (e.g. the procedure name and type, request headers or values set by middleware)
+	//
+	// NOTE: for errors that occur before a procedure is found (e.g. an unknown procedure name), the context only carries the request and whatever is known about the procedure
+	ContextErrorHandler func(ctx *Context, err error) (Serializable, int)
+
+	// PublicError is implemented by errors whose details are safe to send to clients, only these are exposed in production mode
+	PublicError interface {
+		error
+		IsPublic() bool
+	}
+
+	// CodedError is implemented by errors that procedures declare with `Errors`, the code identifies the error for clients
+	//
+	// Once declared, these errors are sent with their exported fields along with their code and message, and with a 400 status code unless they also implement `StatusCode() int`, errors that are not declared are treated like any other error
+	CodedError interface {
+		error
+		ErrorCode() string
+	}
+
 	CastError struct {
 		Expected string
 		Actual   string
@@ -11,12 +40,52 @@ type (
 		Code    int
 		Cause   error
 		Meta    map[string]interface{}
+
+		// A stable, machine-readable code clients can switch on e.g. `user_not_found`, it is included in the response if set
+		PublicCode string
 	}
 
 	RobinError struct {
 		Reason        string
 		OriginalError error
 	}
+
+	// PayloadError is returned when the payload sent with a request does not match the type the procedure expects
+	PayloadError struct {
+		Fields []FieldError `json:"fields"`
+	}
+
+	// FieldError describes a single value in the payload that does not match the expected type
+	FieldError struct {
+		// A JSON pointer (RFC 6901) to the value e.g. `/address/street` or `/items/0`, the payload itself is an empty string
+		Path string `json:"path"`
+
+		// The expected JSON type, one of `string`, `number`, `integer`, `boolean`, `object`, `array` or `date-time`
+		Expected string `json:"expected"`
+
+		// The JSON type of the value that was received
+		Received string `json:"received"`
+	}
+
+	// ValidationError is returned when a payload does not pass the rules declared in its `validate` struct tags
+	ValidationError struct {
+		Fields []ValidationFieldError `json:"fields"`
+	}
+
+	// ValidationFieldError describes a single rule that a value in the payload failed
+	ValidationFieldError struct {
+		// A JSON pointer (RFC 6901) to the value e.g. `/address/street` or `/items/0`, the payload itself is an empty string
+		Path string `json:"path"`
+
+		// The name of the rule that failed e.g. `min`
+		Rule string `json:"rule"`
+
+		// The parameter of the rule if it has one e.g. `3` for `min=3`
+		Param string `json:"param,omitempty"`
+
+		// A description of what was expected e.g. "must be at least 3 characters long"
+		Message string `json:"message"`
+	}
 )
 
 func (ce CastError) Error() string {
@@ -27,10 +96,95 @@ func (e Error) Error() string {
 	return e.Message
 }
 
+func (e Error) IsPublic() bool {
+	return true
+}
+
+// Unwrap returns the cause of the error so that it can be matched with `errors.Is` and `errors.As`
+func (e Error) Unwrap() error {
+	return e.Cause
+}
+
 func (ie RobinError) Error() string {
 	return ie.Reason
 }
 
+func (ie RobinError) Unwrap() error {
+	return ie.OriginalError
+}
+
+func (pe PayloadError) Error() string {
+	switch len(pe.Fields) {
+	case 0:
+		return "Invalid payload provided"
+	case 1:
+		return "Invalid payload provided: " + pe.Fields[0].Error()
+	default:
+		return fmt.Sprintf("Invalid payload provided: %d values do not match the expected types", len(pe.Fields))
+	}
+}
+
+func (pe PayloadError) IsPublic() bool {
+	return true
+}
+
+// MarshalJSON encodes the error with its message, so that it can be returned as is from an error handler
+func (pe PayloadError) MarshalJSON() ([]byte, error) {
+	fields := pe.Fields
+	if fields == nil {
+		fields = []FieldError{}
+	}
+
+	return json.Marshal(struct {
+		Message string       `json:"message"`
+		Fields  []FieldError `json:"fields"`
+	}{Message: pe.Error(), Fields: fields})
+}
+
+func (fe FieldError) Error() string {
+	if fe.Path == "" {
+		return "expected `" + fe.Expected + "`, got `" + fe.Received + "`"
+	}
+
+	return "expected `" + fe.Expected + "` at `" + fe.Path + "`, got `" + fe.Received + "`"
+}
+
+func (ve ValidationError) Error() string {
+	switch len(ve.Fields) {
+	case 0:
+		return "Validation failed"
+	case 1:
+		return "Validation failed: " + ve.Fields[0].Error()
+	default:
+		return fmt.Sprintf("Validation failed: %d values are invalid", len(ve.Fields))
+	}
+}
+
+func (ve ValidationError) IsPublic() bool {
+	return true
+}
+
+// MarshalJSON encodes the error with its message, so that it can be returned as is from an error handler
+func (ve ValidationError) MarshalJSON() ([]byte, error) {
+	fields := ve.Fields
+	if fields == nil {
+		fields = []ValidationFieldError{}
+	}
+
+	return json.Marshal(struct {
+		Message string                 `json:"message"`
+		Fields  []ValidationFieldError `json:"fields"`
+	}{Message: ve.Error(), Fields: fields})
+}
+
+func (vfe ValidationFieldError) Error() string {
+	if vfe.Path == "" {
+		return "value " + vfe.Message
+	}
+
+	return "`" + vfe.Path + "` " + vfe.Message
+}
+
 func NewError(message string, code ...int) *Error {
 	statucCode := 500
 	if len(code) > 0 {
@@ -54,6 +208,11 @@ func (e *Error) WithCause(cause error) *Error {
 	return e
 }
 
+func (e *Error) WithPublicCode(code string) *Error {
+	e.PublicCode = code
+	return e
+}
+
 func (e *Error) WithMeta(meta map[string]interface{}) *Error {
 	if meta == nil {
 		return e
@@ -67,4 +226,13 @@ var (
 	_ error = (*CastError)(nil)
 	_ error = (*Error)(nil)
 	_ error = (*RobinError)(nil)
+	_ error = (*PayloadError)(nil)
+	_ error = (*ValidationError)(nil)
+
+	_ PublicError = (*Error)(nil)
+	_ PublicError = (*PayloadError)(nil)
+	_ PublicError = (*ValidationError)(nil)
+
+	_ json.Marshaler = (*PayloadError)(nil)
+	_ json.Marshaler = (*ValidationError)(nil)
 )
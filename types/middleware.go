@@ -2,6 +2,19 @@ package types
 
 type Middleware func(*Context) error
 
+// Next calls the rest of the chain (the remaining around middleware and then the procedure itself) and returns its result
+type Next func() (any, error)
+
+// AroundMiddleware wraps a procedure call, it can run code before and after the rest of the chain, observe or replace its result and error, or skip it entirely by not calling `next`
+//
+// NOTE: for procedures that stream their result, `next` returns as soon as the stream has been set up and the result is a `Stream`
+type AroundMiddleware func(ctx *Context, next Next) (any, error)
+
+// Guard runs after the payload has been decoded and validated, right before the procedure is called, so unlike a `Middleware`, it can make decisions based on the payload (e.g. "users can only edit their own todos")
+//
+// NOTE: the payload is of the type the procedure expects (e.g. `UpdateTodoPayload`), `Void` for procedures that don't expect one and the request body for procedures that expect a raw payload
+type Guard func(ctx *Context, payload any) error
+
 type ExclusionList []string
 
 // Add adds a name to the exclusion list
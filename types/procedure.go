@@ -3,19 +3,26 @@ package types
 import (
 	"encoding/json"
 	"reflect"
+	"time"
 )
 
 type ProcedureType string
 
 const (
-	ProcedureTypeQuery    ProcedureType = "query"
-	ProcedureTypeMutation ProcedureType = "mutation"
+	ProcedureTypeQuery        ProcedureType = "query"
+	ProcedureTypeMutation     ProcedureType = "mutation"
+	ProcedureTypeSubscription ProcedureType = "subscription"
 )
 
 func (p ProcedureType) String() string {
 	return string(p)
 }
 
+// Stream represents a sequence of values produced over time by a procedure (e.g. a subscription)
+//
+// The transport drives the stream by calling it with a function that delivers each value to the caller, the stream returns when it is done producing values or the emit function returns an error (e.g. the caller went away)
+type Stream func(emit func(any) error) error
+
 type JSONSerializable interface {
 	json.Marshaler
 	json.Unmarshaler
@@ -61,7 +68,7 @@ type Procedure interface {
 	// The name of the procedure
 	Name() string
 
-	// The type of the procedure, one of 'query' or 'mutation'
+	// The type of the procedure, one of 'query', 'mutation' or 'subscription'
 	Type() ProcedureType
 
 	// Implement the Stringer interface
@@ -80,9 +87,17 @@ type Procedure interface {
 	// This is also useful for procedures that expect a raw payload, so we can skip the decoding step and pass the raw payload to the procedure.
 	ExpectedPayloadType() ExpectedPayloadType
 
-	// Call the procedure with the given context and payload
+	// Return a pointer to a new zero value of the payload type that the request body can be decoded into directly, this is passed as is to `Call`
+	NewPayload() any
+
+	// Call the procedure with the given context and payload, the payload can either be of the type the procedure expects or a pointer to it (see `NewPayload`)
+	//
+	// NOTE: subscriptions (and procedures that return a channel or an iterator) return a `Stream` that needs to be driven by the transport to produce values
 	Call(*Context, any) (any, error)
 
+	// Whether the procedure streams its result or not, this is always true for subscriptions
+	IsStreaming() bool
+
 	// Validate the procedure
 	Validate() error
 
@@ -96,11 +111,59 @@ type Procedure interface {
 	// Set the middleware functions for the procedure
 	WithMiddleware(...Middleware) Procedure
 
+	// Around middleware that wrap the whole call, including the regular middleware functions
+	AroundMiddlewareFns() []AroundMiddleware
+
+	// Set around middleware for the procedure at the beginning of the chain
+	// You ideally should not use this method, use WithAroundMiddleware instead unless you absolutely need to prepend middleware to the chain
+	PrependAroundMiddleware(...AroundMiddleware) Procedure
+
+	// Add around middleware for the procedure, the first one added is the outermost
+	WithAroundMiddleware(...AroundMiddleware) Procedure
+
+	// Guards to be executed after the payload has been decoded and before the procedure is called
+	Guards() []Guard
+
+	// Declare the keys that the procedure needs its middleware to set, `Build` fails if none of them do
+	RequireKeys(...ContextKey) Procedure
+
+	// The keys that the procedure needs its middleware to set
+	RequiredKeys() []ContextKey
+
+	// Declare the keys that the procedure's own middleware (or guards) set
+	ProvideKeys(...ContextKey) Procedure
+
+	// The keys that the procedure's own middleware set
+	ProvidedKeys() []ContextKey
+
+	// Declare the errors the procedure can return, the generated client gets them as a union discriminated by their codes
+	Errors(...CodedError) Procedure
+
+	// The errors the procedure declares
+	DeclaredErrors() []CodedError
+
+	// Set an error handler for the procedure's errors that takes precedence over the global one
+	WithErrorHandler(ContextErrorHandler) Procedure
+
+	// The procedure's own error handler, if any
+	ErrorHandler() ContextErrorHandler
+
+	// Add guards for the procedure, these are executed in the order they are added
+	WithGuard(...Guard) Procedure
+
 	ExcludedMiddleware() *ExclusionList
 
 	// Exclude middleware functions from the procedure
 	ExcludeMiddleware(...string) Procedure
 
+	// Set the maximum duration the procedure (including its middleware) is allowed to run for, this overrides the default timeout
+	//
+	// Pass `NoTimeout` to disable the default timeout for the procedure
+	WithTimeout(time.Duration) Procedure
+
+	// Get the maximum duration the procedure is allowed to run for, zero means the default timeout applies
+	Timeout() time.Duration
+
 	// Alias the procedure with a different name for the REST API (and other potential future use cases)
 	WithAlias(string) Procedure
 
@@ -125,6 +188,9 @@ type Procedure interface {
 	WithRawPayload(actualPayloadType any) Procedure
 }
 
+// NoTimeout can be passed to `WithTimeout` to disable the default timeout for a procedure
+const NoTimeout time.Duration = -1
+
 // No-op type to represent a procedure that doesn't return any response or take any payload
 type (
 	_RobinVoid struct{} // Used for identification of robin's special void type
@@ -0,0 +1,153 @@
+package robin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.trulyao.dev/robin"
+)
+
+func Test_PayloadValidation(t *testing.T) {
+	type Item struct {
+		SKU      string `json:"sku" validate:"required,uuid"`
+		Quantity int    `json:"quantity" validate:"min=1,max=10"`
+	}
+
+	type Order struct {
+		Email    string `json:"email" validate:"required,email"`
+		Website  string `json:"website" validate:"omitempty,url"`
+		Currency string `json:"currency" validate:"oneof=USD EUR"`
+		Items    []Item `json:"items" validate:"required,min=1"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Mutation("order", func(ctx *robin.Context, order Order) (int, error) {
+			return len(order.Items), nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		body        string
+		status      int
+		expected    string
+	}{
+		{
+			"valid payload",
+			`{"d": {"email": "jane@example.com", "currency": "EUR", "items": [{"sku": "0b8a1d5e-8f3c-4c2e-9a9e-1f2d3c4b5a69", "quantity": 2}]}}`,
+			http.StatusOK,
+			`{"data":1,"ok":true}`,
+		},
+		{
+			"lists every failed rule",
+			`{"d": {"email": "jane", "website": "example", "currency": "NGN", "items": [{"sku": "abc", "quantity": 11}]}}`,
+			http.StatusUnprocessableEntity,
+			`{"error":{"message":"Validation failed: 5 values are invalid","fields":[` +
+				`{"path":"/email","rule":"email","message":"must be a valid email address"},` +
+				`{"path":"/website","rule":"url","message":"must be a valid URL"},` +
+				`{"path":"/currency","rule":"oneof","param":"USD EUR","message":"must be one of: USD, EUR"},` +
+				`{"path":"/items/0/sku","rule":"uuid","message":"must be a valid UUID"},` +
+				`{"path":"/items/0/quantity","rule":"max","param":"10","message":"must be at most 10"}]},"ok":false}`,
+		},
+		{
+			"empty body",
+			``,
+			http.StatusUnprocessableEntity,
+			`{"error":{"message":"Validation failed: 3 values are invalid","fields":[` +
+				`{"path":"/email","rule":"required","message":"is required"},` +
+				`{"path":"/currency","rule":"oneof","param":"USD EUR","message":"must be one of: USD, EUR"},` +
+				`{"path":"/items","rule":"required","message":"is required"}]},"ok":false}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=m__order", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+
+			if rec.Body.String() != test.expected {
+				t.Errorf("expected body %s, got %s", test.expected, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("rejects invalid rules when building", func(t *testing.T) {
+		type Invalid struct {
+			Age int `json:"age" validate:"email"`
+		}
+
+		r, err := robin.New(robin.Options{})
+		if err != nil {
+			t.Fatalf("failed to create robin instance: %v", err)
+		}
+
+		_, err = r.Add(robin.Query("invalid", func(ctx *robin.Context, body Invalid) (int, error) {
+			return body.Age, nil
+		})).Build()
+		if err == nil || !strings.Contains(err.Error(), "can only be applied to strings") {
+			t.Errorf("expected an invalid rule error, got %v", err)
+		}
+	})
+}
+
+func Test_PayloadValidationOfMaps(t *testing.T) {
+	type Extra struct {
+		Meta map[string]int `json:"meta"`
+	}
+
+	type Settings struct {
+		Labels map[string]string `json:"labels" validate:"required"`
+		Extra  Extra             `json:"extra" validate:"required"`
+	}
+
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Mutation("settings", func(ctx *robin.Context, settings Settings) (int, error) {
+			return len(settings.Labels), nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		body        string
+		status      int
+	}{
+		// Empty maps are not zero values, this is what the generated client mirrors
+		{"empty maps are present", `{"d": {"labels": {}, "extra": {"meta": {}}}}`, http.StatusOK},
+		{"missing maps are not", `{"d": {"labels": null, "extra": {}}}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/?"+robin.ProcNameKey+"=m__settings", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			instance.Handler()(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
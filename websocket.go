@@ -0,0 +1,417 @@
+package robin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.trulyao.dev/robin/internal/websocket"
+	"go.trulyao.dev/robin/types"
+)
+
+type (
+	WebSocketOptions struct {
+		// Enable the WebSocket endpoint
+		Enable bool
+
+		// Route to run the WebSocket endpoint on (default is the robin route with a `/ws` suffix e.g. `/_robin/ws`)
+		Route string
+
+		// Middleware functions executed once when a connection is being upgraded (e.g. for authentication), the connection is rejected if any of them returns an error
+		//
+		// NOTE: state set on the context in these middleware functions is visible to every call made over the connection
+		ConnectionMiddleware []Middleware
+
+		// Origins allowed to open a connection (e.g. `https://example.com`), use `*` to allow every origin
+		//
+		// NOTE: if empty, only same-origin connections (and clients that don't send an `Origin` header) are allowed, since browsers attach cookies to cross-site WebSocket requests
+		Origins []string
+
+		// Maximum size of a single message in bytes (default is 1MB)
+		MaxMessageSize int64
+
+		// Maximum number of calls (including active subscriptions) in progress on a single connection, further calls are rejected until some finish (default is 32)
+		MaxConcurrentCalls int
+
+		// How often the server pings the client to keep the connection alive (default is 30 seconds), set to a negative value to disable
+		PingInterval time.Duration
+
+		// How long to wait for any message (including a reply to a ping) before closing the connection (default is 60 seconds), set to a negative value to disable
+		IdleTimeout time.Duration
+	}
+
+	// A message sent by the client over the WebSocket connection
+	wsRequest struct {
+		// A client-generated ID used to match responses (and subscription events) to requests
+		ID string `json:"id"`
+
+		// One of `query`, `mutation`, `subscription` or `unsubscribe`
+		Type string `json:"type"`
+
+		Name    string          `json:"name,omitempty"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+
+	// A message sent by the server over the WebSocket connection
+	wsResponse struct {
+		ID string `json:"id"`
+		Ok bool   `json:"ok"`
+
+		// The subscription event type, one of `data`, `error` or `end`, this is empty for queries and mutations
+		Event string `json:"event,omitempty"`
+
+		Data  any          `json:"data,omitempty"`
+		Error Serializable `json:"error,omitempty"`
+	}
+
+	// wsConnection holds the state of a single WebSocket connection
+	wsConnection struct {
+		conn *websocket.Conn
+
+		// The upgrade request, every call made over the connection is derived from this
+		request *http.Request
+
+		// The state populated by the connection middleware
+		state types.State
+
+		// Cancel functions for the active calls and subscriptions on the connection
+		mu      sync.Mutex
+		cancels map[string]context.CancelFunc
+
+		// Limits the number of calls in progress on the connection
+		slots chan struct{}
+	}
+)
+
+const wsUnsubscribe = "unsubscribe"
+
+const (
+	DefaultWebSocketMaxConcurrentCalls = 32
+	DefaultWebSocketPingInterval       = 30 * time.Second
+	DefaultWebSocketIdleTimeout        = 60 * time.Second
+)
+
+// WebSocketHandler returns a handler that upgrades requests to WebSocket connections, every connection can multiplex many procedure calls (and subscriptions) which are matched to their responses by a client-generated ID
+func (i *Instance) WebSocketHandler(opts WebSocketOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		i.robin.serveWebSocket(w, req, opts)
+	}
+}
+
+// serveWebSocket upgrades the request and serves procedure calls over the connection until it is closed
+func (r *Robin) serveWebSocket(w http.ResponseWriter, req *http.Request, opts WebSocketOptions) {
+	// The connection middleware run before the upgrade so that a rejection can be sent as a regular HTTP error
+	connCtx := types.NewContext(req, &w)
+
+	if !websocket.IsUpgradeRequest(req) {
+		r.sendError(w, connCtx, types.Error{Message: "Expected a WebSocket upgrade request", Code: http.StatusBadRequest})
+		return
+	}
+
+	if !isAllowedOrigin(req, opts.Origins) {
+		r.sendError(w, connCtx, types.Error{Message: "Origin not allowed", Code: http.StatusForbidden})
+		return
+	}
+
+	for _, middleware := range opts.ConnectionMiddleware {
+		if err := middleware(connCtx); err != nil {
+			r.sendError(w, connCtx, err)
+			return
+		}
+	}
+
+	conn, err := websocket.Upgrade(w, req, opts.MaxMessageSize)
+	if err != nil {
+		r.sendError(w, connCtx, types.Error{Message: "Failed to upgrade connection", Code: http.StatusBadRequest, Cause: err})
+		return
+	}
+
+	// Values attached to the context by the connection middleware are visible to every call made over the connection
+	ctx, cancel := context.WithCancel(connCtx.Context())
+	defer cancel()
+
+	// Upgraded connections are not tracked by the server, so they have to be closed here when it shuts down
+	stopOnShutdown := context.AfterFunc(r.stopping.context(), func() {
+		_ = conn.Close(websocket.CloseGoingAway, "server is shutting down")
+	})
+	defer stopOnShutdown()
+
+	maxConcurrentCalls := opts.MaxConcurrentCalls
+	if maxConcurrentCalls <= 0 {
+		maxConcurrentCalls = DefaultWebSocketMaxConcurrentCalls
+	}
+
+	c := &wsConnection{
+		conn:    conn,
+		request: req.WithContext(ctx),
+		state:   connCtx.State.Copy(),
+		cancels: make(map[string]context.CancelFunc),
+		slots:   make(chan struct{}, maxConcurrentCalls),
+	}
+
+	if idleTimeout := withDefault(opts.IdleTimeout, DefaultWebSocketIdleTimeout); idleTimeout > 0 {
+		conn.SetIdleTimeout(idleTimeout)
+	}
+
+	if pingInterval := withDefault(opts.PingInterval, DefaultWebSocketPingInterval); pingInterval > 0 {
+		go keepAlive(ctx, conn, pingInterval)
+	}
+
+	if r.debug {
+		slog.Info("WebSocket connection opened", slog.String("remoteAddr", conn.RemoteAddr().String()))
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if r.debug && !errors.Is(err, websocket.ErrClosed) {
+				slog.Error("Failed to read WebSocket message", slog.String("error", err.Error()))
+			}
+
+			break
+		}
+
+		if messageType != websocket.TextMessage {
+			_ = conn.Close(websocket.CloseUnsupportedData, "only text messages are supported")
+			break
+		}
+
+		var request wsRequest
+		if err := json.Unmarshal(message, &request); err != nil || request.ID == "" {
+			r.sendWebSocketError(c, c.newCallContext(c.request.Context(), request), request.ID, types.Error{Message: "Invalid message, expected `{id, type, name, payload}`", Code: http.StatusBadRequest})
+			continue
+		}
+
+		if request.Type == wsUnsubscribe {
+			c.cancel(request.ID)
+			continue
+		}
+
+		// The call is rejected rather than queued so that an unsubscribe message is never stuck behind it
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			r.sendWebSocketError(c, c.newCallContext(c.request.Context(), request), request.ID, types.Error{
+				Message: fmt.Sprintf("Too many calls in progress on the connection, expected at most %d", cap(c.slots)),
+				Code:    http.StatusTooManyRequests,
+			})
+			continue
+		}
+
+		// Every call runs in its own goroutine so that a slow call (or a subscription) doesn't block the others on the connection
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-c.slots }()
+			r.handleWebSocketCall(c, request)
+		}()
+	}
+
+	// Stop all the calls and subscriptions that are still running on the connection
+	cancel()
+	_ = conn.Close(websocket.CloseNormalClosure, "")
+
+	if r.debug {
+		slog.Info("WebSocket connection closed", slog.String("remoteAddr", conn.RemoteAddr().String()))
+	}
+}
+
+// keepAlive pings the client periodically until the connection is done, the replies keep the idle timeout from closing the connection
+func keepAlive(ctx context.Context, conn *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// isAllowedOrigin checks the `Origin` header against the allowed origins, only same-origin requests are allowed if there are none
+//
+// Requests without an `Origin` header are not made by browsers, so they can't be used for cross-site WebSocket hijacking and are always allowed
+func isAllowedOrigin(req *http.Request, origins []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || slices.Contains(origins, "*") {
+		return true
+	}
+
+	if len(origins) > 0 {
+		return slices.Contains(origins, origin)
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// withDefault returns the value if it is set (non-zero), otherwise the default
+func withDefault[T time.Duration](value, defaultValue T) T {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// handleWebSocketCall executes a single call received over the connection and sends its result (or events for subscriptions) back to the client
+func (r *Robin) handleWebSocketCall(c *wsConnection, request wsRequest) {
+	ctx, cancel := context.WithCancel(c.request.Context())
+	defer cancel()
+
+	callCtx := c.newCallContext(ctx, request)
+
+	if !c.register(request.ID, cancel) {
+		r.sendWebSocketError(c, callCtx, request.ID, types.Error{Message: fmt.Sprintf("A call with the ID `%s` is already in progress", request.ID), Code: http.StatusConflict})
+		return
+	}
+	defer c.cancel(request.ID)
+
+	defer func() {
+		if e := recover(); e != nil {
+			if !r.trapPanic {
+				// Mimic what net/http does for panics in handlers; log it and drop the connection
+				captured := capturePanic(e)
+				slog.Error("Panic serving WebSocket call", slog.Any("panic", captured.value), slog.String("stack", string(captured.stack)))
+				_ = c.conn.Close(websocket.CloseInternalServerErr, "internal server error")
+				return
+			}
+
+			r.sendWebSocketError(c, callCtx, request.ID, r.makePanicError(callCtx, e))
+		}
+	}()
+
+	procedureType := ProcedureType(request.Type)
+	switch procedureType {
+	case ProcedureTypeQuery, ProcedureTypeMutation, ProcedureTypeSubscription:
+	default:
+		r.sendWebSocketError(c, callCtx, request.ID, types.RobinError{
+			Reason: fmt.Sprintf("Invalid procedure type, expect one of 'query', 'mutation' or 'subscription', got %s", request.Type),
+		})
+		return
+	}
+
+	procedure, found := r.findProcedure(request.Name, procedureType)
+	if !found {
+		r.sendWebSocketError(c, callCtx, request.ID, r.makeMissingProcedureError(request.Name, procedureType))
+		return
+	}
+
+	result, err := r.callProcedure(callCtx, procedure)
+	if err != nil {
+		r.sendWebSocketError(c, callCtx, request.ID, err)
+		return
+	}
+
+	stream, isStream := result.(types.Stream)
+	if !isStream {
+		r.sendWebSocketMessage(c, wsResponse{ID: request.ID, Ok: true, Data: result})
+		return
+	}
+
+	err = stream(func(value any) error {
+		// Stop producing values as soon as the client unsubscribes or the connection is closed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return r.sendWebSocketMessage(c, wsResponse{ID: request.ID, Ok: true, Event: "data", Data: value})
+	})
+
+	// Nobody is listening anymore if the subscription was cancelled
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		if r.debug {
+			slog.Error("An error occurred in subscription", slog.Any("error", err))
+		}
+
+		errorResponse, _ := r.handleError(callCtx, err)
+		_ = r.sendWebSocketMessage(c, wsResponse{ID: request.ID, Ok: false, Event: "error", Error: errorResponse})
+	}
+
+	_ = r.sendWebSocketMessage(c, wsResponse{ID: request.ID, Ok: true, Event: "end"})
+}
+
+// newCallContext creates the context for a call made over the connection, it starts with the values set by the connection middleware
+func (c *wsConnection) newCallContext(ctx context.Context, request wsRequest) *Context {
+	// Headers can't be sent over an established connection, so whatever is set by the procedure is discarded
+	var w http.ResponseWriter = &batchResponseWriter{header: make(http.Header)}
+
+	callCtx := types.NewContext(newPayloadRequest(c.request.WithContext(ctx), request.Payload), &w)
+	callCtx.State = c.state.Copy()
+	callCtx.SetProcedureName(request.Name)
+	callCtx.SetProcedureType(ProcedureType(request.Type))
+
+	return callCtx
+}
+
+// sendWebSocketError converts the error into a response using the configured error handler and sends it to the client
+func (r *Robin) sendWebSocketError(c *wsConnection, ctx *Context, id string, err error) {
+	if r.debug {
+		slog.Error("An error occurred in WebSocket call", slog.Any("error", err))
+	}
+
+	errorResponse, _ := r.handleError(ctx, err)
+	_ = r.sendWebSocketMessage(c, wsResponse{ID: id, Ok: false, Error: errorResponse})
+}
+
+func (r *Robin) sendWebSocketMessage(c *wsConnection, response wsResponse) error {
+	message, err := json.Marshal(response)
+	if err != nil {
+		slog.Error("Failed to marshal WebSocket message", slog.String("error", err.Error()))
+		return err
+	}
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		if r.debug && !errors.Is(err, websocket.ErrClosed) {
+			slog.Error("Failed to write WebSocket message", slog.String("error", err.Error()))
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// register tracks the cancel function of an active call, it returns false if a call with the same ID is already active
+func (c *wsConnection) register(id string, cancel context.CancelFunc) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.cancels[id]; exists {
+		return false
+	}
+
+	c.cancels[id] = cancel
+	return true
+}
+
+// cancel stops an active call (or subscription) and stops tracking it
+func (c *wsConnection) cancel(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cancel, exists := c.cancels[id]; exists {
+		cancel()
+		delete(c.cancels, id)
+	}
+}
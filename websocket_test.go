@@ -0,0 +1,267 @@
+package robin_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.trulyao.dev/robin"
+)
+
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string, headers map[string]string) (*wsTestClient, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	request := "GET / HTTP/1.1\r\n" +
+		"Host: " + strings.TrimPrefix(url, "http://") + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	for key, value := range headers {
+		request += key + ": " + value + "\r\n"
+	}
+	request += "\r\n"
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsTestClient{conn: conn, reader: reader}, response
+}
+
+func (c *wsTestClient) send(t *testing.T, message string) {
+	t.Helper()
+
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | byte(len(message))}
+	frame = append(frame, mask...)
+	for i := range len(message) {
+		frame = append(frame, message[i]^mask[i%4])
+	}
+
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+func (c *wsTestClient) receive(t *testing.T) map[string]any {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatalf("failed to read frame header: %v", err)
+	}
+
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			t.Fatalf("failed to read frame length: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatalf("failed to read frame payload: %v", err)
+	}
+
+	var message map[string]any
+	if err := json.Unmarshal(payload, &message); err != nil {
+		t.Fatalf("failed to decode message %q: %v", payload, err)
+	}
+
+	return message
+}
+
+func Test_WebSocketCalls(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("whoami", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			user, _ := ctx.Get("user").(string)
+			return user, nil
+		})).
+		Add(robin.Subscription("count", func(ctx *robin.Context, n int, sink *robin.Sink[int]) error {
+			for i := 1; i <= n; i++ {
+				if err := sink.Send(i); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	server := httptest.NewServer(instance.WebSocketHandler(robin.WebSocketOptions{
+		Enable: true,
+		ConnectionMiddleware: []robin.Middleware{
+			func(ctx *robin.Context) error {
+				token := ctx.Header("Authorization")
+				if token == "" {
+					return robin.Error{Message: "Unauthorized", Code: http.StatusUnauthorized}
+				}
+
+				ctx.Set("user", strings.TrimPrefix(token, "Bearer "))
+				return nil
+			},
+		},
+	}))
+	defer server.Close()
+
+	t.Run("rejects connection when connection middleware fails", func(t *testing.T) {
+		_, response := dialWebSocket(t, server.URL, nil)
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, response.StatusCode)
+		}
+	})
+
+	client, response := dialWebSocket(t, server.URL, map[string]string{"Authorization": "Bearer john"})
+	defer client.conn.Close()
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, response.StatusCode)
+	}
+
+	t.Run("calls a query with connection state", func(t *testing.T) {
+		client.send(t, `{"id": "1", "type": "query", "name": "whoami"}`)
+
+		message := client.receive(t)
+		if message["id"] != "1" || message["ok"] != true || message["data"] != "john" {
+			t.Errorf("unexpected message: %v", message)
+		}
+	})
+
+	t.Run("streams subscription events", func(t *testing.T) {
+		client.send(t, `{"id": "2", "type": "subscription", "name": "count", "payload": 2}`)
+
+		var events []string
+		for range 3 {
+			message := client.receive(t)
+			if message["id"] != "2" {
+				t.Fatalf("unexpected message: %v", message)
+			}
+
+			events = append(events, fmt.Sprintf("%v:%v", message["event"], message["data"]))
+		}
+
+		if got := strings.Join(events, ","); got != "data:1,data:2,end:<nil>" {
+			t.Errorf("unexpected events: %s", got)
+		}
+	})
+}
+
+func Test_WebSocketLimits(t *testing.T) {
+	r, err := robin.New(robin.Options{})
+	if err != nil {
+		t.Fatalf("failed to create robin instance: %v", err)
+	}
+
+	instance, err := r.
+		Add(robin.Query("ping", func(ctx *robin.Context, _ robin.Void) (string, error) {
+			return "pong", nil
+		})).
+		Add(robin.Subscription("wait", func(ctx *robin.Context, _ robin.Void, sink *robin.Sink[int]) error {
+			<-ctx.Done()
+			return nil
+		})).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build robin instance: %v", err)
+	}
+
+	server := httptest.NewServer(instance.WebSocketHandler(robin.WebSocketOptions{
+		Enable:             true,
+		MaxConcurrentCalls: 1,
+		PingInterval:       -1,
+		IdleTimeout:        200 * time.Millisecond,
+	}))
+	defer server.Close()
+
+	t.Run("rejects cross-origin connections by default", func(t *testing.T) {
+		_, response := dialWebSocket(t, server.URL, map[string]string{"Origin": "https://evil.test"})
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("expected status %d, got %d", http.StatusForbidden, response.StatusCode)
+		}
+
+		client, response := dialWebSocket(t, server.URL, map[string]string{"Origin": server.URL})
+		defer client.conn.Close()
+
+		if response.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("expected same-origin connections to be allowed, got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("limits concurrent calls", func(t *testing.T) {
+		client, _ := dialWebSocket(t, server.URL, nil)
+		defer client.conn.Close()
+
+		client.send(t, `{"id": "1", "type": "subscription", "name": "wait"}`)
+		client.send(t, `{"id": "2", "type": "query", "name": "ping"}`)
+
+		message := client.receive(t)
+		if message["id"] != "2" || message["ok"] != false {
+			t.Fatalf("expected the second call to be rejected, got %v", message)
+		}
+
+		client.send(t, `{"id": "1", "type": "unsubscribe"}`)
+
+		// The slot is freed once the subscription has stopped
+		for attempt := 0; ; attempt++ {
+			client.send(t, `{"id": "3", "type": "query", "name": "ping"}`)
+			if message := client.receive(t); message["ok"] == true {
+				break
+			}
+
+			if attempt == 10 {
+				t.Fatalf("expected the call to succeed once the subscription stopped")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("closes idle connections", func(t *testing.T) {
+		client, _ := dialWebSocket(t, server.URL, nil)
+		defer client.conn.Close()
+
+		var header [2]byte
+		if _, err := io.ReadFull(client.reader, header[:]); err != nil {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+
+		if opcode := header[0] & 0x0F; opcode != 0x8 {
+			t.Errorf("expected a close frame, got opcode %d", opcode)
+		}
+	})
+}